@@ -0,0 +1,78 @@
+package sqlgen
+
+// MinDerivation is the cheapest way to derive a symbol completely. Depth
+// and Tokens are minimized independently, so a single derivation may not
+// reach both at once.
+type MinDerivation struct {
+	// Depth is the minimal height of a derivation tree, 0 for a terminal.
+	Depth int
+	// Tokens is the minimal number of tokens in a derivation.
+	Tokens int
+}
+
+// DefaultMaxDepth and DefaultMaxTokens are the budgets of a derivation
+// unless told otherwise. The depth bounds the recursion of any grammar, so
+// that generation terminates, and the tokens bound the size of a statement,
+// which a deep enough tree could make exponential.
+const (
+	DefaultMaxDepth  = 100
+	DefaultMaxTokens = 10000
+)
+
+// ComputeMinDerivations computes the minimal derivation of every production
+// in prodMap. Productions which can't derive any finite statement, such as
+// `a: a 'x'`, are absent from the result.
+func ComputeMinDerivations(prodMap map[string]*Production) map[string]MinDerivation {
+	mins := make(map[string]MinDerivation, len(prodMap))
+	// Iterate to a fixpoint: every round finds the productions whose
+	// derivations are one level deeper, or improves the known ones.
+	for changed := true; changed; {
+		changed = false
+		for name, prod := range prodMap {
+			best, found := mins[name]
+			for _, body := range prod.bodyList {
+				m, ok := minOfSeq(body.seq, mins)
+				if !ok {
+					continue
+				}
+				if !found {
+					best, found, changed = m, true, true
+					continue
+				}
+				if m.Depth < best.Depth {
+					best.Depth, changed = m.Depth, true
+				}
+				if m.Tokens < best.Tokens {
+					best.Tokens, changed = m.Tokens, true
+				}
+			}
+			if found {
+				mins[name] = best
+			}
+		}
+	}
+	return mins
+}
+
+// minOfSeq returns the minimal derivation of a production through a branch,
+// and false if a symbol of the branch has no known derivation.
+func minOfSeq(seq []string, mins map[string]MinDerivation) (MinDerivation, bool) {
+	ret := MinDerivation{Depth: 1}
+	for _, s := range seq {
+		if lit, ok := literal(s); ok {
+			if lit != "" {
+				ret.Tokens++
+			}
+			continue
+		}
+		m, ok := mins[s]
+		if !ok {
+			return MinDerivation{}, false
+		}
+		if m.Depth+1 > ret.Depth {
+			ret.Depth = m.Depth + 1
+		}
+		ret.Tokens += m.Tokens
+	}
+	return ret, true
+}
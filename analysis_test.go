@@ -0,0 +1,28 @@
+package sqlgen
+
+import "testing"
+
+func TestComputeMinDerivations(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' expr 'FROM' 't'\n| 'SELECT' expr",
+		"expr: expr '+' expr\n| '(' expr ')'\n| 'a'",
+		"opt: %empty\n| 'x'",
+		"loop: loop 'x'",
+		"uses_loop: loop\n| opt",
+	)
+	mins := ComputeMinDerivations(prodMap)
+	expected := map[string]MinDerivation{
+		"stmt":      {Depth: 2, Tokens: 2},
+		"expr":      {Depth: 1, Tokens: 1},
+		"opt":       {Depth: 1, Tokens: 0},
+		"uses_loop": {Depth: 2, Tokens: 0},
+	}
+	for name, exp := range expected {
+		if got, ok := mins[name]; !ok || got != exp {
+			t.Errorf("%s: expect %+v, get %+v", name, exp, got)
+		}
+	}
+	if _, ok := mins["loop"]; ok {
+		t.Error("a production without finite derivation should be absent")
+	}
+}
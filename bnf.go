@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Production struct {
@@ -15,32 +16,41 @@ type Production struct {
 	bodyList BodyList
 }
 
+// String returns the production in the syntax of a grammar file, which
+// parses back to the same production.
 func (p *Production) String() string {
 	var sb strings.Builder
 	sb.WriteString(p.head)
-	writeOptNum(&sb, p.maxLoop)
-	sb.WriteString(": ")
-	firstBody := p.bodyList[0]
-	sb.WriteString(strings.Join(firstBody.seq, " "))
-
-	if isLiteral(firstBody.seq[0]) {
-		writeOptNum(&sb, firstBody.randomFactor)
+	if p.maxLoop != 1 {
+		writeOptNum(&sb, p.maxLoop)
 	}
-
-	for i := 1; i < len(p.bodyList); i++ {
-		body := p.bodyList[i]
-		sb.WriteString("\n")
-		sb.WriteString("| ")
-		sb.WriteString(strings.Join(body.seq, " "))
-		if isLiteral(firstBody.seq[0]) {
-			writeOptNum(&sb, firstBody.randomFactor)
+	sb.WriteString(":")
+	for i, body := range p.bodyList {
+		if i != 0 {
+			sb.WriteString("\n|")
+		}
+		if len(body.seq) == 0 {
+			sb.WriteString(" %empty")
+		} else {
+			sb.WriteString(" ")
+			sb.WriteString(strings.Join(body.seq, " "))
+		}
+		writeOptLabel(&sb, body.label)
+		if body.randomFactor != 1 {
+			writeOptNum(&sb, body.randomFactor)
 		}
 	}
-
 	sb.WriteString("\n")
 	return sb.String()
 }
 
+func writeOptLabel(sb *strings.Builder, label string) {
+	if label != "" {
+		sb.WriteString(" #")
+		sb.WriteString(label)
+	}
+}
+
 func writeOptNum(sb *strings.Builder, i int) {
 	sb.WriteString(" [")
 	sb.WriteString(strconv.Itoa(i))
@@ -50,6 +60,11 @@ func writeOptNum(sb *strings.Builder, i int) {
 type Body = struct {
 	seq          []string
 	randomFactor int
+	// label names the branch, so that it can be addressed by something
+	// more stable than its index. It is empty for an unlabeled branch. It
+	// is written #label at the end of the branch. A # always begins a
+	// label, comments are written // or /* */.
+	label string
 }
 
 type BodyList = []Body
@@ -61,6 +76,13 @@ type Scanner struct {
 	startPos int
 
 	q quote
+	// lastSize is the size of the last rune read, for UnreadRune.
+	lastSize int
+	// comments holds the comments skipped so far.
+	comments []span
+	// parens is the depth of the parentheses around the current position,
+	// inside which a comma separates arguments.
+	parens int
 
 	errs  []error
 	warns []error
@@ -74,14 +96,35 @@ func (s *Scanner) Errors() (warns []error, errs []error) {
 // Errorf tells scanner something is wrong.
 // Scanner satisfies yyLexer interface which need this function.
 func (s *Scanner) Errorf(format string, a ...interface{}) (err error) {
+	return s.errorAt(s.startPos, s.curPos, format, a...)
+}
+
+// errorAt returns an error about the token from start to end.
+func (s *Scanner) errorAt(start, end int, format string, a ...interface{}) error {
 	str := fmt.Sprintf(format, a...)
-	val := s.s[s.startPos:]
-	err = fmt.Errorf("column %d near \"%s\"%s",
-		s.curPos, val, str)
-	return
+	val := s.s[start:]
+	if i := strings.IndexByte(val, '\n'); i >= 0 {
+		val = val[:i]
+	}
+	line := 1 + strings.Count(s.s[:start], "\n")
+	column := end - strings.LastIndexByte(s.s[:start], '\n') - 1
+	if str != "" {
+		str = ": " + str
+	}
+	return fmt.Errorf("line %d column %d near \"%s\"%s", line, column, val, str)
+}
+
+// duplicateLabel returns the error for the label which is defined again at
+// offset.
+func duplicateLabel(yylex yyLexer, offset int, label string) error {
+	const format = "duplicate label #%s"
+	s, ok := yylex.(*Scanner)
+	if !ok {
+		return yylex.Errorf(format, label)
+	}
+	return s.errorAt(offset, offset+1+len(label), format, label)
 }
 
-// AppendError sets error into scanner.
 // Scanner satisfies yyLexer interface which need this function.
 func (s *Scanner) AppendError(err error) {
 	if err == nil {
@@ -90,22 +133,28 @@ func (s *Scanner) AppendError(err error) {
 	s.errs = append(s.errs, err)
 }
 
-func (s *Scanner) ReadRune() (rune, error) {
+// ReadRune decodes the UTF-8 rune at the current position, and returns its
+// size in bytes. Positions stay byte offsets.
+// Scanner satisfies io.RuneScanner.
+func (s *Scanner) ReadRune() (rune, int, error) {
 	if s.curPos >= len(s.s) {
-		return 0, io.EOF
+		s.lastSize = 0
+		return 0, 0, io.EOF
 	}
-	ret := s.s[s.curPos]
-	s.curPos += 1
-	return rune(ret), nil
+	ret, size := utf8.DecodeRuneInString(s.s[s.curPos:])
+	s.curPos += size
+	s.lastSize = size
+	return ret, size, nil
 }
 
+// UnreadRune unreads the rune returned by the last ReadRune.
 func (s *Scanner) UnreadRune() error {
-	if s.curPos > 0 {
-		s.curPos -= 1
-		return nil
-	} else {
+	if s.lastSize <= 0 {
 		return io.ErrNoProgress
 	}
+	s.curPos -= s.lastSize
+	s.lastSize = 0
+	return nil
 }
 
 // Lex returns a token and store the token Value in v.
@@ -116,18 +165,18 @@ func (s *Scanner) UnreadRune() error {
 func (s *Scanner) Lex(v *yySymType) int {
 	var r rune
 	var err error
-	s.startPos = s.curPos
-	// Skip spaces.
-	for {
-		r, err = s.ReadRune()
-		panicIfNonEOF(err)
-		if err == io.EOF {
-			return 0
-		}
-		if !unicode.IsSpace(r) || s.q.isInsideStr() {
-			break
-		}
-		s.startPos += 1
+	// Skip spaces and comments.
+	pos, ok := s.skipSpaces(s.curPos, true)
+	s.curPos, s.startPos = pos, pos
+	v.offset = pos
+	if !ok {
+		s.AppendError(s.Errorf("unterminated comment"))
+		return 0
+	}
+	r, _, err = s.ReadRune()
+	panicIfNonEOF(err)
+	if err == io.EOF {
+		return 0
 	}
 
 	// Handle delimiter.
@@ -144,6 +193,22 @@ func (s *Scanner) Lex(v *yySymType) int {
 		} else if r == ']' {
 			v.ident = "]"
 			return RightBr
+		} else if r == ';' {
+			v.ident = ";"
+			return Semicolon
+		} else if r == '(' {
+			s.parens++
+			v.ident = "("
+			return LeftParen
+		} else if r == ')' {
+			if s.parens > 0 {
+				s.parens--
+			}
+			v.ident = ")"
+			return RightParen
+		} else if r == ',' && s.parens > 0 {
+			v.ident = ","
+			return Comma
 		}
 	}
 
@@ -155,14 +220,34 @@ func (s *Scanner) Lex(v *yySymType) int {
 	// Handle identifier.
 	stringBuf := string(r)
 	for {
-		r, err = s.ReadRune()
+		r, _, err = s.ReadRune()
 		panicIfNonEOF(err)
 		if err == io.EOF {
+			if s.q.isInsideStr() {
+				s.AppendError(s.Errorf("unterminated string"))
+				s.q = quote{}
+			}
 			break
 		}
-		if (unicode.IsSpace(r) || isDelimiter(r) || isBracket(r)) && !s.q.isInsideStr() {
+		// Handle escape sequence, see unescapeLiteral.
+		if r == '\\' && s.q.isInsideStr() {
+			stringBuf += string(r)
+			r, _, err = s.ReadRune()
+			panicIfNonEOF(err)
+			if err == io.EOF {
+				continue
+			}
+			if !isEscape(r) {
+				s.AppendError(s.Errorf("unknown escape sequence \\%c", r))
+			}
+			stringBuf += string(r)
+			continue
+		}
+		if (unicode.IsSpace(r) || isDelimiter(r) || isBracket(r) || isParen(r) || r == ',' && s.parens > 0 ||
+			s.commentAt(s.curPos-1)) && !s.q.isInsideStr() {
 			if err := s.UnreadRune(); err != nil {
-				panic(fmt.Sprintf("Unable to unread rune: %s.", string(r)))
+				s.AppendError(s.Errorf("unable to unread rune: %s", string(r)))
+				return invalid
 			}
 			break
 		}
@@ -171,7 +256,8 @@ func (s *Scanner) Lex(v *yySymType) int {
 		// Handle end str.
 		if r == '\'' || r == '"' {
 			if !s.q.isInsideStr() {
-				panic(fmt.Sprintf("unexpected character: `%s` after `%s`", string(r), stringBuf))
+				s.AppendError(s.Errorf("unexpected character: `%s` after `%s`", string(r), stringBuf[:len(stringBuf)-len(string(r))]))
+				return invalid
 			}
 			if s.q.tryToggle(r) {
 				break
@@ -179,23 +265,122 @@ func (s *Scanner) Lex(v *yySymType) int {
 		}
 	}
 	v.ident = s.s[s.startPos:s.curPos]
+	switch v.ident {
+	case "%empty":
+		return empty
+	case "%start":
+		return startDirective
+	case "%token":
+		return tokenDirective
+	case "%include":
+		return includeDirective
+	case "%replace":
+		return replaceDirective
+	case "%extend":
+		return extendDirective
+	case "%delete":
+		return deleteDirective
+	}
+	if v.ident[0] == '#' {
+		if len(v.ident) == 1 {
+			s.AppendError(s.Errorf("missing label name after #"))
+			return invalid
+		}
+		v.ident = v.ident[1:]
+		return label
+	}
+	if !isLiteral(v.ident) && s.colonFollows() {
+		return head
+	}
 	return identifier
 }
 
+// colonFollows reports whether the next tokens are optional parameters, an
+// optional [N] and a colon, which make the current identifier the head of a
+// production.
+func (s *Scanner) colonFollows() bool {
+	i, _ := s.skipSpaces(s.curPos, false)
+	if i < len(s.s) && s.s[i] == '(' {
+		end := matchParen(s.s, i, nil)
+		if end < 0 {
+			return false
+		}
+		i, _ = s.skipSpaces(end+1, false)
+	}
+	if i < len(s.s) && s.s[i] == '[' {
+		end := strings.IndexByte(s.s[i:], ']')
+		if end < 0 {
+			return false
+		}
+		i, _ = s.skipSpaces(i+end+1, false)
+	}
+	return i < len(s.s) && s.s[i] == ':'
+}
+
+// skipSpaces returns the position of the first byte from i which is neither
+// a space nor in a comment. It returns false along with the beginning of an
+// unterminated comment. The skipped comments are recorded if record is true.
+func (s *Scanner) skipSpaces(i int, record bool) (int, bool) {
+	for i < len(s.s) {
+		start := i
+		switch {
+		case unicode.IsSpace(runeAt(s.s, i)):
+			_, size := utf8.DecodeRuneInString(s.s[i:])
+			i += size
+			continue
+		case strings.HasPrefix(s.s[i:], "/*"):
+			end := strings.Index(s.s[i+2:], "*/")
+			if end < 0 {
+				return i, false
+			}
+			i += 2 + end + 2
+		case s.commentAt(i):
+			end := strings.IndexByte(s.s[i:], '\n')
+			if end < 0 {
+				end = len(s.s) - i
+			}
+			i += end
+		default:
+			return i, true
+		}
+		if record {
+			s.comments = append(s.comments, span{start, i})
+		}
+	}
+	return i, true
+}
+
+// commentAt reports whether a comment begins at i: `//` or `/*`.
+func (s *Scanner) commentAt(i int) bool {
+	rest := s.s[i:]
+	return strings.HasPrefix(rest, "//") || strings.HasPrefix(rest, "/*")
+}
+
+// runeAt decodes the rune at the byte offset i of str.
+func runeAt(str string, i int) rune {
+	r, _ := utf8.DecodeRuneInString(str[i:])
+	return r
+}
+
 // reset resets the sql string to be scanned.
 func (s *Scanner) reset(str string) {
 	s.s = str
 	s.curPos = 0
 	s.startPos = 0
+	s.lastSize = 0
+	s.comments = s.comments[:0]
+	s.parens = 0
+	s.q = quote{}
 	s.errs = s.errs[:0]
 	s.warns = s.warns[:0]
 }
 
 // Parser represents a parser instance. Some temporary objects are stored in it to reduce object allocation during Parse function.
 type Parser struct {
-	result *Production
-	src    string
-	lexer  Scanner
+	edits []edit
+	start string
+	src   string
+	lexer Scanner
 
 	// the following fields are used by yyParse to reduce allocation.
 	cache  []yySymType
@@ -203,6 +388,12 @@ type Parser struct {
 	yyVAL  yySymType
 }
 
+// Start returns the production named by the %start directive of the last
+// parsed grammar, or "" if there is none.
+func (parser *Parser) Start() string {
+	return parser.start
+}
+
 // NewParser returns a Parser object.
 func NewParser() *Parser {
 	return &Parser{
@@ -210,9 +401,47 @@ func NewParser() *Parser {
 	}
 }
 
+// Parse parses a single production.
 func (parser *Parser) Parse(bnf string) (result *Production, warns []error, err error) {
+	prods, warns, err := parser.ParseAll(bnf)
+	if err != nil {
+		return nil, warns, err
+	}
+	if len(prods) != 1 {
+		return nil, warns, fmt.Errorf("expect 1 production, get %d", len(prods))
+	}
+	return prods[0], warns, nil
+}
+
+// ParseAll parses a whole grammar. A production lasts until the head of the
+// next one, and may be terminated by a semicolon. The %replace, %extend and
+// %delete statements apply to the productions before them, and %include is
+// an error since there is no file to resolve it from, see ParseGrammarFile.
+// Templates are expanded into the instances the grammar refers to.
+func (parser *Parser) ParseAll(bnf string) (result []*Production, warns []error, err error) {
+	warns, err = parser.parse(bnf)
+	if err != nil {
+		return nil, warns, err
+	}
+	for _, e := range parser.edits {
+		if e.op == opInclude {
+			return nil, warns, fmt.Errorf("can't %%include \"%s\" without a grammar file", e.path)
+		}
+		if result, err = applyEdit(result, e); err != nil {
+			return nil, warns, err
+		}
+	}
+	if result, err = expandTemplates(result); err != nil {
+		return nil, warns, err
+	}
+	return result, warns, nil
+}
+
+// parse parses the statements of a grammar into parser.edits.
+func (parser *Parser) parse(bnf string) (warns []error, err error) {
 	parser.src = bnf
-	parser.result = nil
+	parser.edits = nil
+	parser.start = ""
 
 	var l yyLexer
 	parser.lexer.reset(bnf)
@@ -226,9 +455,9 @@ func (parser *Parser) Parse(bnf string) (result *Production, warns []error, err
 		warns = nil
 	}
 	if len(errs) != 0 {
-		return nil, warns, errors.Trace(errs[0])
+		return warns, errors.Trace(errs[0])
 	}
-	return parser.result, warns, nil
+	return warns, nil
 }
 
 func panicIfNonEOF(err error) {
@@ -238,13 +467,22 @@ func panicIfNonEOF(err error) {
 }
 
 func isDelimiter(r rune) bool {
-	return r == '|' || r == ':'
+	return r == '|' || r == ':' || r == ';'
 }
 
 func isBracket(r rune) bool {
 	return r == '[' || r == ']'
 }
 
+func isParen(r rune) bool {
+	return r == '(' || r == ')'
+}
+
+// span is the range [start, end) of bytes of the source.
+type span struct {
+	start, end int
+}
+
 type quote struct {
 	c rune
 }
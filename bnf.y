@@ -32,22 +32,45 @@ import (
 
 %type 	<item>
 	Production
+	ArgList
+	ArgsOpt
 	BodyList
 	Body
+	Symbols
+	Alternative
 	NumberOpt
 
+%type	<ident>
+	LabelOpt
+	TokenTextOpt
+	Symbol
+
 %token	<item>
 	Colon
 	OrBranch
 	LeftBr
 	RightBr
+	Semicolon
+	LeftParen
+	RightParen
+	Comma
 
 %type	<ident>
 	identifier      "identifier"
 
 %token  <ident>
 	identifier
+	head
 	number
+	empty	"%empty"
+	startDirective	"%start"
+	tokenDirective	"%token"
+	includeDirective	"%include"
+	replaceDirective	"%replace"
+	extendDirective	"%extend"
+	deleteDirective	"%delete"
+	label
+	invalid
 
 %right identifier
 
@@ -55,42 +78,172 @@ import (
 
 %%
 
-Start: 	Production
+Start: 	StatementList
+
+StatementList:
+	{}
+|	StatementList Statement SemicolonOpt
+
+Statement:
+	Production
+	{
+		parser.edits = append(parser.edits, edit{op: opDefine, prod: $1.(*Production)})
+	}
+|	replaceDirective Production
+	{
+		parser.edits = append(parser.edits, edit{op: opReplace, prod: $2.(*Production)})
+	}
+|	extendDirective Production
+	{
+		parser.edits = append(parser.edits, edit{op: opExtend, prod: $2.(*Production)})
+	}
+|	deleteDirective Symbol
+	{
+		parser.edits = append(parser.edits, edit{op: opDelete, prod: &Production{head: $2}})
+	}
+|	includeDirective identifier
+	{
+		path, ok := literal($2)
+		if !ok || path == "" {
+			yylex.AppendError(yylex.Errorf("expect a quoted file name after %%include"))
+			return 1
+		}
+		parser.edits = append(parser.edits, edit{op: opInclude, path: path})
+	}
+|	startDirective identifier
 	{
-		parser.result = $1.(*Production)
+		parser.start = $2
 	}
+|	tokenDirective identifier TokenTextOpt
+	{
+		text := $3
+		if text == "" {
+			text = strconv.Quote($2)
+		} else if !isLiteral(text) {
+			yylex.AppendError(yylex.Errorf("expect a string after %%token %s", $2))
+			return 1
+		}
+		prod := &Production{ head: $2, maxLoop: 1, bodyList: BodyList{{seq: []string{text}, randomFactor: 1}} }
+		parser.edits = append(parser.edits, edit{op: opDefine, prod: prod})
+	}
+
+TokenTextOpt:
+	{
+		$$ = ""
+	}
+|	identifier
+
+SemicolonOpt:
+	{}
+|	Semicolon
 
 Production:
-	identifier NumberOpt Colon BodyList
+	head ArgsOpt NumberOpt Colon BodyList
+	{
+		name := $1
+		if args := $2.([]string); args != nil {
+			name = instanceName(name, args)
+		}
+		$$ = &Production{ head: name, maxLoop: $3.(int), bodyList: $5.(BodyList) }
+	}
+
+ArgsOpt:
+	{
+		$$ = []string(nil)
+	}
+|	LeftParen ArgList RightParen
 	{
-		$$ = &Production{ head: $1, maxLoop: $2.(int), bodyList: $4.(BodyList) }
+		$$ = $2
+	}
+
+ArgList:
+	Symbol
+	{
+		$$ = []string{$1}
+	}
+|	ArgList Comma Symbol
+	{
+		$$ = append($1.([]string), $3)
+	}
+
+Symbol:
+	identifier
+|	identifier LeftParen ArgList RightParen
+	{
+		if isLiteral($1) {
+			yylex.AppendError(yylex.Errorf("unexpected arguments of a string"))
+			return 1
+		}
+		$$ = instanceName($1, $3.([]string))
 	}
 
 BodyList:
-	Body NumberOpt
+	Alternative
 	{
-		body := $1.(Body)
-		body.randomFactor = $2.(int)
-		$$ = BodyList{body}
+		$$ = BodyList{$1.(Body)}
 	}
-|	BodyList OrBranch Body NumberOpt
+|	BodyList OrBranch Alternative
 	{
-		body := $3.(Body)
-        	body.randomFactor = $4.(int)
-		$$ = append($1.(BodyList), body)
+		bodyList, body := $1.(BodyList), $3.(Body)
+		if body.label != "" {
+			for _, b := range bodyList {
+				if b.label == body.label {
+					yylex.AppendError(duplicateLabel(yylex, $<offset>3, body.label))
+					return 1
+				}
+			}
+		}
+		$$ = append(bodyList, body)
 	}
 
-Body:
-	Body identifier
+Alternative:
+	Body LabelOpt NumberOpt
 	{
 		body := $1.(Body)
-		body.seq = append(body.seq, $2)
+		body.label = $2
+		body.randomFactor = $3.(int)
 		$$ = body
+		$<offset>$ = $<offset>2
 	}
-|	identifier
+
+Body:
+	{
+		$$ = Body{}
+	}
+|	empty
+	{
+		$$ = Body{}
+	}
+|	Symbols
+	{
+		$$ = Body{seq: $1.([]string)}
+	}
+|	empty Symbols
+	{
+		yylex.AppendError(yylex.Errorf("%%empty must be the whole alternative"))
+		return 1
+	}
+
+Symbols:
+	Symbol
+	{
+		$$ = []string{$1}
+	}
+|	Symbols Symbol
+	{
+		$$ = append($1.([]string), $2)
+	}
+|	Symbols empty
+	{
+		yylex.AppendError(yylex.Errorf("%%empty must be the whole alternative"))
+		return 1
+	}
+
+LabelOpt:
 	{
-		$$ = Body{seq: []string{$1}}
+		$$ = ""
 	}
+|	label
 
 NumberOpt:
 	{
@@ -38,91 +38,222 @@ type yyXError struct {
 }
 
 const (
-	yyDefault  = 57352
-	yyEOFCode  = 57344
-	Colon      = 57346
-	LeftBr     = 57348
-	OrBranch   = 57347
-	RightBr    = 57349
-	yyErrCode  = 57345
-	identifier = 57350
-	number     = 57351
+	yyDefault        = 57366
+	yyEOFCode        = 57344
+	Colon            = 57346
+	Comma            = 57353
+	LeftBr           = 57348
+	LeftParen        = 57351
+	OrBranch         = 57347
+	RightBr          = 57349
+	RightParen       = 57352
+	Semicolon        = 57350
+	deleteDirective  = 57363
+	empty            = 57357
+	yyErrCode        = 57345
+	extendDirective  = 57362
+	head             = 57355
+	identifier       = 57354
+	includeDirective = 57360
+	invalid          = 57365
+	label            = 57364
+	number           = 57356
+	replaceDirective = 57361
+	startDirective   = 57358
+	tokenDirective   = 57359
 
 	yyMaxDepth = 200
-	yyTabOfs   = -9
+	yyTabOfs   = -36
 )
 
 var (
 	yyXLAT = map[int]int{
-		57344: 0,  // $end (10x)
-		57350: 1,  // identifier (8x)
-		57347: 2,  // OrBranch (8x)
-		57348: 3,  // LeftBr (5x)
-		57346: 4,  // Colon (3x)
-		57355: 5,  // NumberOpt (3x)
-		57353: 6,  // Body (2x)
-		57354: 7,  // BodyList (1x)
-		57356: 8,  // Production (1x)
-		57349: 9,  // RightBr (1x)
-		57357: 10, // Start (1x)
-		57352: 11, // $default (0x)
-		57345: 12, // error (0x)
-		57351: 13, // number (0x)
+		57355: 0,  // head (34x)
+		57344: 1,  // $end (33x)
+		57363: 2,  // deleteDirective (32x)
+		57362: 3,  // extendDirective (32x)
+		57360: 4,  // includeDirective (32x)
+		57361: 5,  // replaceDirective (32x)
+		57358: 6,  // startDirective (32x)
+		57359: 7,  // tokenDirective (32x)
+		57350: 8,  // Semicolon (28x)
+		57354: 9,  // identifier (19x)
+		57347: 10, // OrBranch (18x)
+		57348: 11, // LeftBr (16x)
+		57364: 12, // label (11x)
+		57357: 13, // empty (9x)
+		57379: 14, // Symbol (9x)
+		57353: 15, // Comma (6x)
+		57352: 16, // RightParen (6x)
+		57346: 17, // Colon (5x)
+		57374: 18, // Production (3x)
+		57380: 19, // Symbols (3x)
+		57367: 20, // Alternative (2x)
+		57368: 21, // ArgList (2x)
+		57370: 22, // Body (2x)
+		57351: 23, // LeftParen (2x)
+		57373: 24, // NumberOpt (2x)
+		57369: 25, // ArgsOpt (1x)
+		57371: 26, // BodyList (1x)
+		57372: 27, // LabelOpt (1x)
+		57349: 28, // RightBr (1x)
+		57375: 29, // SemicolonOpt (1x)
+		57376: 30, // Start (1x)
+		57377: 31, // Statement (1x)
+		57378: 32, // StatementList (1x)
+		57381: 33, // TokenTextOpt (1x)
+		57366: 34, // $default (0x)
+		57345: 35, // error (0x)
+		57365: 36, // invalid (0x)
+		57356: 37, // number (0x)
 	}
 
 	yySymNames = []string{
+		"head",
 		"$end",
+		"deleteDirective",
+		"extendDirective",
+		"includeDirective",
+		"replaceDirective",
+		"startDirective",
+		"tokenDirective",
+		"Semicolon",
 		"identifier",
 		"OrBranch",
 		"LeftBr",
+		"label",
+		"empty",
+		"Symbol",
+		"Comma",
+		"RightParen",
 		"Colon",
-		"NumberOpt",
+		"Production",
+		"Symbols",
+		"Alternative",
+		"ArgList",
 		"Body",
+		"LeftParen",
+		"NumberOpt",
+		"ArgsOpt",
 		"BodyList",
-		"Production",
+		"LabelOpt",
 		"RightBr",
+		"SemicolonOpt",
 		"Start",
+		"Statement",
+		"StatementList",
+		"TokenTextOpt",
 		"$default",
 		"error",
+		"invalid",
 		"number",
 	}
 
 	yyReductions = []struct{ xsym, components int }{
 		{0, 1},
-		{10, 1},
-		{8, 4},
-		{7, 2},
-		{7, 4},
-		{6, 2},
-		{6, 1},
-		{5, 0},
-		{5, 3},
+		{30, 1},
+		{32, 0},
+		{32, 3},
+		{31, 1},
+		{31, 2},
+		{31, 2},
+		{31, 2},
+		{31, 2},
+		{31, 2},
+		{31, 3},
+		{33, 0},
+		{33, 1},
+		{29, 0},
+		{29, 1},
+		{18, 5},
+		{25, 0},
+		{25, 3},
+		{21, 1},
+		{21, 3},
+		{14, 1},
+		{14, 4},
+		{26, 1},
+		{26, 3},
+		{20, 3},
+		{22, 0},
+		{22, 1},
+		{22, 1},
+		{22, 2},
+		{19, 1},
+		{19, 2},
+		{19, 2},
+		{27, 0},
+		{27, 1},
+		{24, 0},
+		{24, 3},
 	}
 
 	yyXErrors = map[yyXError]string{}
 
-	yyParseTab = [17][]uint8{
+	yyParseTab = [52][]uint8{
 		// 0
-		{1: 12, 8: 11, 10: 10},
-		{9},
-		{8},
-		{3: 14, 2, 13},
-		{4: 17},
+		{34, 34, 34, 34, 34, 34, 34, 34, 30: 37, 32: 38},
+		{1: 36},
+		{47, 35, 43, 42, 44, 41, 45, 46, 18: 40, 31: 39},
+		{23, 23, 23, 23, 23, 23, 23, 23, 87, 29: 86},
+		{32, 32, 32, 32, 32, 32, 32, 32, 32},
 		// 5
-		{1: 15},
-		{9: 16},
-		{1, 2: 1, 4: 1},
-		{1: 20, 6: 19, 18},
-		{7, 2: 23},
+		{47, 18: 85},
+		{47, 18: 84},
+		{9: 52, 14: 83},
+		{9: 82},
+		{9: 81},
 		// 10
-		{2, 22, 2, 14, 5: 21},
-		{3, 3, 3, 3},
-		{6, 2: 6},
-		{4, 4, 4, 4},
-		{1: 20, 6: 24},
+		{9: 78},
+		{11: 20, 17: 20, 23: 49, 25: 48},
+		{11: 60, 17: 2, 24: 59},
+		{9: 52, 14: 51, 21: 50},
+		{15: 55, 58},
 		// 15
-		{2, 22, 2, 14, 5: 25},
-		{5, 2: 5},
+		{15: 18, 18},
+		{16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 15: 16, 16, 23: 53},
+		{9: 52, 14: 51, 21: 54},
+		{15: 55, 56},
+		{9: 52, 14: 57},
+		// 20
+		{15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15: 15, 15},
+		{15: 17, 17},
+		{11: 19, 17: 19},
+		{17: 63},
+		{9: 61},
+		// 25
+		{28: 62},
+		{1, 1, 1, 1, 1, 1, 1, 1, 1, 10: 1, 17: 1},
+		{11, 11, 11, 11, 11, 11, 11, 11, 11, 52, 11, 11, 11, 67, 69, 19: 68, 65, 22: 66, 26: 64},
+		{21, 21, 21, 21, 21, 21, 21, 21, 21, 10: 76},
+		{14, 14, 14, 14, 14, 14, 14, 14, 14, 10: 14},
+		// 30
+		{4, 4, 4, 4, 4, 4, 4, 4, 4, 10: 4, 4, 74, 27: 73},
+		{10, 10, 10, 10, 10, 10, 10, 10, 10, 52, 10, 10, 10, 14: 69, 19: 72},
+		{9, 9, 9, 9, 9, 9, 9, 9, 9, 52, 9, 9, 9, 71, 70},
+		{7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7},
+		{6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6},
+		// 35
+		{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+		{8, 8, 8, 8, 8, 8, 8, 8, 8, 52, 8, 8, 8, 71, 70},
+		{2, 2, 2, 2, 2, 2, 2, 2, 2, 10: 2, 60, 24: 75},
+		{3, 3, 3, 3, 3, 3, 3, 3, 3, 10: 3, 3},
+		{12, 12, 12, 12, 12, 12, 12, 12, 12, 10: 12},
+		// 40
+		{11, 11, 11, 11, 11, 11, 11, 11, 11, 52, 11, 11, 11, 67, 69, 19: 68, 77, 22: 66},
+		{13, 13, 13, 13, 13, 13, 13, 13, 13, 10: 13},
+		{25, 25, 25, 25, 25, 25, 25, 25, 25, 80, 33: 79},
+		{26, 26, 26, 26, 26, 26, 26, 26, 26},
+		{24, 24, 24, 24, 24, 24, 24, 24, 24},
+		// 45
+		{27, 27, 27, 27, 27, 27, 27, 27, 27},
+		{28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{29, 29, 29, 29, 29, 29, 29, 29, 29},
+		{30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{31, 31, 31, 31, 31, 31, 31, 31, 31},
+		// 50
+		{33, 33, 33, 33, 33, 33, 33, 33},
+		{22, 22, 22, 22, 22, 22, 22, 22},
 	}
 )
 
@@ -161,7 +292,7 @@ func yylex1(yylex yyLexer, lval *yySymType) (n int) {
 }
 
 func yyParse(yylex yyLexer, parser *Parser) int {
-	const yyError = 12
+	const yyError = 35
 
 	yyEx, _ := yylex.(yyLexerEx)
 	var yyn int
@@ -337,41 +468,147 @@ yynewstate:
 	}
 
 	switch r {
-	case 1:
+	case 4:
 		{
-			parser.result = yyS[yypt-0].item.(*Production)
+			parser.edits = append(parser.edits, edit{op: opDefine, prod: yyS[yypt-0].item.(*Production)})
 		}
-	case 2:
+	case 5:
 		{
-			parser.yyVAL.item = &Production{head: yyS[yypt-3].ident, maxLoop: yyS[yypt-2].item.(int), bodyList: yyS[yypt-0].item.(BodyList)}
+			parser.edits = append(parser.edits, edit{op: opReplace, prod: yyS[yypt-0].item.(*Production)})
 		}
-	case 3:
+	case 6:
 		{
-			body := yyS[yypt-1].item.(Body)
-			body.randomFactor = yyS[yypt-0].item.(int)
-			parser.yyVAL.item = BodyList{body}
+			parser.edits = append(parser.edits, edit{op: opExtend, prod: yyS[yypt-0].item.(*Production)})
 		}
-	case 4:
+	case 7:
 		{
-			body := yyS[yypt-1].item.(Body)
-			body.randomFactor = yyS[yypt-0].item.(int)
-			parser.yyVAL.item = append(yyS[yypt-3].item.(BodyList), body)
+			parser.edits = append(parser.edits, edit{op: opDelete, prod: &Production{head: yyS[yypt-0].ident}})
 		}
-	case 5:
+	case 8:
+		{
+			path, ok := literal(yyS[yypt-0].ident)
+			if !ok || path == "" {
+				yylex.AppendError(yylex.Errorf("expect a quoted file name after %%include"))
+				return 1
+			}
+			parser.edits = append(parser.edits, edit{op: opInclude, path: path})
+		}
+	case 9:
 		{
-			body := yyS[yypt-1].item.(Body)
-			body.seq = append(body.seq, yyS[yypt-0].ident)
+			parser.start = yyS[yypt-0].ident
+		}
+	case 10:
+		{
+			text := yyS[yypt-0].ident
+			if text == "" {
+				text = strconv.Quote(yyS[yypt-1].ident)
+			} else if !isLiteral(text) {
+				yylex.AppendError(yylex.Errorf("expect a string after %%token %s", yyS[yypt-1].ident))
+				return 1
+			}
+			prod := &Production{head: yyS[yypt-1].ident, maxLoop: 1, bodyList: BodyList{{seq: []string{text}, randomFactor: 1}}}
+			parser.edits = append(parser.edits, edit{op: opDefine, prod: prod})
+		}
+	case 11:
+		{
+			parser.yyVAL.ident = ""
+		}
+	case 15:
+		{
+			name := yyS[yypt-4].ident
+			if args := yyS[yypt-3].item.([]string); args != nil {
+				name = instanceName(name, args)
+			}
+			parser.yyVAL.item = &Production{head: name, maxLoop: yyS[yypt-2].item.(int), bodyList: yyS[yypt-0].item.(BodyList)}
+		}
+	case 16:
+		{
+			parser.yyVAL.item = []string(nil)
+		}
+	case 17:
+		{
+			parser.yyVAL.item = yyS[yypt-1].item
+		}
+	case 18:
+		{
+			parser.yyVAL.item = []string{yyS[yypt-0].ident}
+		}
+	case 19:
+		{
+			parser.yyVAL.item = append(yyS[yypt-2].item.([]string), yyS[yypt-0].ident)
+		}
+	case 21:
+		{
+			if isLiteral(yyS[yypt-3].ident) {
+				yylex.AppendError(yylex.Errorf("unexpected arguments of a string"))
+				return 1
+			}
+			parser.yyVAL.ident = instanceName(yyS[yypt-3].ident, yyS[yypt-1].item.([]string))
+		}
+	case 22:
+		{
+			parser.yyVAL.item = BodyList{yyS[yypt-0].item.(Body)}
+		}
+	case 23:
+		{
+			bodyList, body := yyS[yypt-2].item.(BodyList), yyS[yypt-0].item.(Body)
+			if body.label != "" {
+				for _, b := range bodyList {
+					if b.label == body.label {
+						yylex.AppendError(duplicateLabel(yylex, yyS[yypt-0].offset, body.label))
+						return 1
+					}
+				}
+			}
+			parser.yyVAL.item = append(bodyList, body)
+		}
+	case 24:
+		{
+			body := yyS[yypt-2].item.(Body)
+			body.label = yyS[yypt-1].ident
+			body.randomFactor = yyS[yypt-0].item.(int)
 			parser.yyVAL.item = body
+			parser.yyVAL.offset = yyS[yypt-1].offset
 		}
-	case 6:
+	case 25:
 		{
-			parser.yyVAL.item = Body{seq: []string{yyS[yypt-0].ident}}
+			parser.yyVAL.item = Body{}
 		}
-	case 7:
+	case 26:
+		{
+			parser.yyVAL.item = Body{}
+		}
+	case 27:
+		{
+			parser.yyVAL.item = Body{seq: yyS[yypt-0].item.([]string)}
+		}
+	case 28:
+		{
+			yylex.AppendError(yylex.Errorf("%%empty must be the whole alternative"))
+			return 1
+		}
+	case 29:
+		{
+			parser.yyVAL.item = []string{yyS[yypt-0].ident}
+		}
+	case 30:
+		{
+			parser.yyVAL.item = append(yyS[yypt-1].item.([]string), yyS[yypt-0].ident)
+		}
+	case 31:
+		{
+			yylex.AppendError(yylex.Errorf("%%empty must be the whole alternative"))
+			return 1
+		}
+	case 32:
+		{
+			parser.yyVAL.ident = ""
+		}
+	case 34:
 		{
 			parser.yyVAL.item = 1
 		}
-	case 8:
+	case 35:
 		{
 			num, err := strconv.ParseInt(yyS[yypt-1].ident, 10, 32)
 			if err != nil {
@@ -2,6 +2,7 @@ package sqlgen
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -49,3 +50,209 @@ func TestProductionString(t *testing.T) {
 		t.Errorf("output string inconsistent: %s, %s", prodStr, prodStr2)
 	}
 }
+
+func TestParseLiterals(t *testing.T) {
+	parser := NewParser()
+	bnf := `quotes: '\'' "'" '"' "\"" '\\' 'a b' "|:[]" 'tab\t'`
+	prod, _, err := parser.Parse(bnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"'", "'", `"`, `"`, `\`, "a b", "|:[]", "tab\t"}
+	seq := prod.bodyList[0].seq
+	if len(seq) != len(expected) {
+		t.Fatalf("expect %d terminals, get %v", len(expected), seq)
+	}
+	for i, s := range seq {
+		if lit, ok := literal(s); !ok || lit != expected[i] {
+			t.Errorf("expect terminal %q, get %q", expected[i], s)
+		}
+	}
+
+	if _, _, err := parser.Parse(`a: 'unterminated`); err == nil {
+		t.Error("expect error for unterminated string")
+	}
+	for _, bnf := range []string{`a: '\x01'`, `a: "\a"`, `a: 'b\u00df'`} {
+		if _, _, err := parser.Parse(bnf); err == nil || !strings.Contains(err.Error(), "unknown escape sequence") {
+			t.Errorf("%s: expect an unknown escape error, get %v", bnf, err)
+		}
+	}
+	prod, _, err = parser.Parse(`a: '\n\r\t\0\'\"\\'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lit, _ := literal(prod.bodyList[0].seq[0]); lit != "\n\r\t\x00'\"\\" {
+		t.Errorf("unexpected escapes: %q", lit)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	parser := NewParser()
+	cases := map[string][]int{
+		"opt_x: | x":         {0, 1},
+		"opt_x: %empty | x":  {0, 1},
+		"opt_x: x\n| %empty": {1, 0},
+		"opt_x:":             {0},
+		"opt_x: | x [2] |":   {0, 1, 0},
+	}
+	for bnf, lens := range cases {
+		prod, _, err := parser.Parse(bnf)
+		if err != nil {
+			t.Errorf("%s: %v", bnf, err)
+			continue
+		}
+		if len(prod.bodyList) != len(lens) {
+			t.Errorf("%s: expect %d alternatives, get %d", bnf, len(lens), len(prod.bodyList))
+			continue
+		}
+		for i, l := range lens {
+			if len(prod.bodyList[i].seq) != l {
+				t.Errorf("%s: expect %d symbols in alternative %d, get %v", bnf, l, i, prod.bodyList[i].seq)
+			}
+		}
+	}
+	for _, bnf := range []string{"s: 'a' %empty 'b'", "s: %empty 'a'", "s: 'a' %empty"} {
+		if _, _, err := parser.Parse(bnf); err == nil || !strings.Contains(err.Error(), "%empty must be the whole alternative") {
+			t.Errorf("%s: expect %%empty error, get %v", bnf, err)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	parser := NewParser()
+	prod, _, err := parser.Parse("stmt: alter_event_stmt #alter_event\n| 'x' #x [3]\n| y\n| #none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"alter_event", "x", "", "none"}
+	for i, label := range expected {
+		if b := prod.bodyList[i]; b.label != label {
+			t.Errorf("branch %d: expect label '%s', get '%s'", i, label, b.label)
+		}
+	}
+	if w := prod.bodyList[1].randomFactor; w != 3 {
+		t.Errorf("expect weight 3 after a label, get %d", w)
+	}
+	if len(prod.bodyList[3].seq) != 0 {
+		t.Errorf("expect an empty labeled branch, get %v", prod.bodyList[3].seq)
+	}
+	again, _, err := parser.Parse(prod.String())
+	if err != nil || again.bodyList[0].label != "alter_event" {
+		t.Errorf("labels don't survive String: %s", prod.String())
+	}
+
+	if _, _, err := parser.Parse("stmt: a #x\n| b\n| c #x\n"); err == nil ||
+		err.Error() != `line 3 column 6 near "#x": duplicate label #x` {
+		t.Errorf("expect error for duplicate labels at the second label, get %v", err)
+	}
+	// A # always begins a label, comments are written // or /* */.
+	for _, bnf := range []string{"stmt: a #x b\n| c", "stmt: a # x\n| c", "# comment\nstmt: a"} {
+		if _, _, err := parser.Parse(bnf); err == nil {
+			t.Errorf("%q: expect error for a misplaced label", bnf)
+		}
+	}
+	prod, _, err = parser.Parse("stmt: 'x' // TODO\n| 'y' // TODO")
+	if err != nil || prod.bodyList[0].label != "" || prod.bodyList[1].label != "" {
+		t.Errorf("expect comments rather than labels, get %v, %v", prod, err)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	parser := NewParser()
+	prods, _, err := parser.ParseAll(`// The statements.
+stmt: select_stmt // the common one
+| /* disabled: delete_stmt
+| */ insert_stmt #insert // a label, then a comment
+select_stmt /* head */ : 'SELECT' '#' '//' "/*"
+insert_stmt: 'INSERT'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prods) != 3 {
+		t.Fatalf("expect 3 productions, get %d", len(prods))
+	}
+	stmt := prods[0].bodyList
+	if len(stmt) != 2 || stmt[1].seq[0] != "insert_stmt" || stmt[1].label != "insert" {
+		t.Errorf("unexpected alternatives: %v", stmt)
+	}
+	if seq := prods[1].bodyList[0].seq; len(seq) != 4 || seq[3] != `"/*"` {
+		t.Errorf("comment markers in strings should be terminals: %v", seq)
+	}
+
+	prods, _, err = parser.ParseAll("//comment\nstmt: 'a'\n//another comment\n  /*indented*/\n| 'b' #label\n//last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prods) != 1 || len(prods[0].bodyList) != 2 || prods[0].bodyList[1].label != "label" {
+		t.Errorf("unexpected productions: %v", prods)
+	}
+
+	if _, _, err := parser.ParseAll("a: 'x' /* unterminated"); err == nil {
+		t.Error("expect error for unterminated comment")
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	parser := NewParser()
+	prods, _, err := parser.ParseAll(`%token CREATE "CREATE"
+%token TABLE_SYM 'TABLE';
+%token IF
+stmt: CREATE TABLE_SYM IF
+%start stmt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parser.Start() != "stmt" {
+		t.Errorf("expect start 'stmt', get '%s'", parser.Start())
+	}
+	expected := map[string]string{"CREATE": "CREATE", "TABLE_SYM": "TABLE", "IF": "IF"}
+	for _, p := range prods {
+		if p.head == "stmt" {
+			continue
+		}
+		if lit, _ := literal(p.bodyList[0].seq[0]); lit != expected[p.head] {
+			t.Errorf("%s: expect %q, get %q", p.head, expected[p.head], lit)
+		}
+	}
+	if len(prods) != 4 {
+		t.Errorf("expect 4 productions, get %d", len(prods))
+	}
+
+	if _, _, err := parser.ParseAll("stmt: 'x'"); err != nil || parser.Start() != "" {
+		t.Errorf("the start should be reset, get '%s', %v", parser.Start(), err)
+	}
+	if _, _, err := parser.ParseAll("%token CREATE CREATE_SYM"); err == nil {
+		t.Error("expect error for a token text which is not a string")
+	}
+}
+
+func TestParseUTF8(t *testing.T) {
+	parser := NewParser()
+	// 'à' is encoded as C3 A0, and A0 alone would be a no-break space.
+	prods, _, err := parser.ParseAll("表: 'ß' \"中文\" '😀' 'à' 'é\\''\u00a0列\n列: 'x' #标签")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prods) != 2 || prods[0].head != "表" || prods[1].head != "列" {
+		t.Fatalf("unexpected productions: %v", prods)
+	}
+	expected := []string{"ß", "中文", "😀", "à", "é'"}
+	seq := prods[0].bodyList[0].seq
+	if len(seq) != len(expected)+1 || seq[len(expected)] != "列" {
+		t.Fatalf("unexpected symbols: %q", seq)
+	}
+	for i, exp := range expected {
+		if lit, ok := literal(seq[i]); !ok || lit != exp {
+			t.Errorf("expect terminal %q, get %q", exp, seq[i])
+		}
+	}
+	if l := prods[1].bodyList[0].label; l != "标签" {
+		t.Errorf("expect label 标签, get %q", l)
+	}
+
+	// Columns are byte offsets.
+	_, _, err = parser.ParseAll("列: 'ß' : x")
+	if err == nil || !strings.Contains(err.Error(), "line 1 column 11") {
+		t.Errorf("expect an error at byte 11, get %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package sqlgen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// NewAlternative returns an alternative of symbols, with weight 1 and no
+// label, to be added to a production by a GrammarBuilder.
+func NewAlternative(symbols ...Symbol) Alternative {
+	body := Body{randomFactor: 1}
+	for _, s := range symbols {
+		if s.Kind == Terminal {
+			body.seq = append(body.seq, quoteLiteral(s.Name))
+		} else {
+			body.seq = append(body.seq, s.Name)
+		}
+	}
+	return Alternative{body: body}
+}
+
+// WithLabel returns a copy of a with the label.
+func (a Alternative) WithLabel(label string) Alternative {
+	a.body.label = label
+	return a
+}
+
+// WithWeight returns a copy of a with the weight.
+func (a Alternative) WithWeight(weight int) Alternative {
+	a.body.randomFactor = weight
+	return a
+}
+
+// GrammarBuilder creates a grammar, or edits a copy of one. Productions
+// keep the order in which they are added.
+type GrammarBuilder struct {
+	prods []*Production
+	start string
+}
+
+// NewGrammarBuilder returns a builder of an empty grammar.
+func NewGrammarBuilder() *GrammarBuilder {
+	return &GrammarBuilder{}
+}
+
+// Builder returns a builder which starts from g. Building never changes g.
+func (g *Grammar) Builder() *GrammarBuilder {
+	b := &GrammarBuilder{start: g.start}
+	for _, p := range g.prods {
+		copied := *p
+		copied.bodyList = append(BodyList(nil), p.bodyList...)
+		b.prods = append(b.prods, &copied)
+	}
+	return b
+}
+
+// SetStart sets the %start of the grammar, "" for none.
+func (b *GrammarBuilder) SetStart(name string) {
+	b.start = name
+}
+
+// Add adds the production name.
+func (b *GrammarBuilder) Add(name string, alts ...Alternative) error {
+	if b.find(name) >= 0 {
+		return fmt.Errorf("production '%s' already exists", name)
+	}
+	prod, err := newProduction(name, alts)
+	if err != nil {
+		return err
+	}
+	b.prods = append(b.prods, prod)
+	return nil
+}
+
+// Replace replaces the alternatives of the production name.
+func (b *GrammarBuilder) Replace(name string, alts ...Alternative) error {
+	i := b.find(name)
+	if i < 0 {
+		return fmt.Errorf("production '%s' not found", name)
+	}
+	prod, err := newProduction(name, alts)
+	if err != nil {
+		return err
+	}
+	prod.maxLoop = b.prods[i].maxLoop
+	b.prods[i] = prod
+	return nil
+}
+
+// Remove removes the production name. The productions which refer to it
+// must be changed too before Build.
+func (b *GrammarBuilder) Remove(name string) error {
+	i := b.find(name)
+	if i < 0 {
+		return fmt.Errorf("production '%s' not found", name)
+	}
+	b.prods = append(b.prods[:i], b.prods[i+1:]...)
+	return nil
+}
+
+// AddAlternative appends alt to the production name.
+func (b *GrammarBuilder) AddAlternative(name string, alt Alternative) error {
+	i := b.find(name)
+	if i < 0 {
+		return fmt.Errorf("production '%s' not found", name)
+	}
+	bodies := append(BodyList(nil), b.prods[i].bodyList...)
+	return b.setAlternatives(i, append(bodies, alt.body))
+}
+
+// ReplaceAlternative replaces the alternative of the production name which
+// has the label branch, or else the index branch.
+func (b *GrammarBuilder) ReplaceAlternative(name, branch string, alt Alternative) error {
+	i, j, err := b.findAlternative(name, branch)
+	if err != nil {
+		return err
+	}
+	bodies := append(BodyList(nil), b.prods[i].bodyList...)
+	bodies[j] = alt.body
+	return b.setAlternatives(i, bodies)
+}
+
+// RemoveAlternative removes an alternative like ReplaceAlternative
+// replaces it. The last alternative of a production can't be removed.
+func (b *GrammarBuilder) RemoveAlternative(name, branch string) error {
+	i, j, err := b.findAlternative(name, branch)
+	if err != nil {
+		return err
+	}
+	bodies := append(BodyList(nil), b.prods[i].bodyList[:j]...)
+	return b.setAlternatives(i, append(bodies, b.prods[i].bodyList[j+1:]...))
+}
+
+// Build checks the grammar and returns it. The builder may go on building
+// another grammar.
+func (b *GrammarBuilder) Build() (*Grammar, error) {
+	g := &Grammar{start: b.start}
+	for _, p := range b.prods {
+		copied := *p
+		copied.bodyList = append(BodyList(nil), p.bodyList...)
+		g.prods = append(g.prods, &copied)
+	}
+	var err error
+	if g.prodMap, err = buildProdMap(g.prods); err != nil {
+		return nil, err
+	}
+	if _, ok := g.prodMap[g.start]; g.start != "" && !ok {
+		return nil, fmt.Errorf("start production '%s' not found", g.start)
+	}
+	return g, nil
+}
+
+func (b *GrammarBuilder) find(name string) int {
+	for i, p := range b.prods {
+		if p.head == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *GrammarBuilder) findAlternative(name, branch string) (int, int, error) {
+	i := b.find(name)
+	if i < 0 {
+		return 0, 0, fmt.Errorf("production '%s' not found", name)
+	}
+	j := findBranch(b.prods[i].bodyList, branch)
+	if j < 0 {
+		return 0, 0, fmt.Errorf("production '%s' has no branch '%s'", name, branch)
+	}
+	return i, j, nil
+}
+
+func (b *GrammarBuilder) setAlternatives(i int, bodies BodyList) error {
+	prod := &Production{head: b.prods[i].head, maxLoop: b.prods[i].maxLoop, bodyList: bodies}
+	if err := checkAlternatives(prod); err != nil {
+		return err
+	}
+	b.prods[i] = prod
+	return nil
+}
+
+// newProduction returns the production name of alts, checking that it can
+// be written in the syntax of a grammar file.
+func newProduction(name string, alts []Alternative) (*Production, error) {
+	if !isSymbolName(name) {
+		return nil, fmt.Errorf("invalid production name '%s'", name)
+	}
+	prod := &Production{head: name, maxLoop: 1}
+	for _, a := range alts {
+		prod.bodyList = append(prod.bodyList, a.body)
+	}
+	return prod, checkAlternatives(prod)
+}
+
+func checkAlternatives(prod *Production) error {
+	if len(prod.bodyList) == 0 {
+		return fmt.Errorf("production '%s' has no alternative", prod.head)
+	}
+	labels := map[string]struct{}{}
+	for _, body := range prod.bodyList {
+		for _, s := range body.seq {
+			if !isLiteral(s) && !isSymbolName(s) {
+				return fmt.Errorf("invalid symbol '%s' in production '%s'", s, prod.head)
+			}
+		}
+		if body.label == "" {
+			continue
+		}
+		if !isSymbolName(body.label) {
+			return fmt.Errorf("invalid label '%s' in production '%s'", body.label, prod.head)
+		}
+		if _, ok := labels[body.label]; ok {
+			return fmt.Errorf("duplicate label #%s in production '%s'", body.label, prod.head)
+		}
+		labels[body.label] = struct{}{}
+	}
+	return nil
+}
+
+// isSymbolName reports whether the scanner reads name as a single symbol,
+// which may be an instance of a template.
+func isSymbolName(name string) bool {
+	if tmpl, args, ok := splitInstance(name); ok {
+		for _, a := range args {
+			if !isLiteral(a) && !isSymbolName(a) {
+				return false
+			}
+		}
+		return isSymbolName(tmpl)
+	}
+	if name == "" || name[0] == '#' || name[0] == '%' || strings.ContainsAny(name, `'"(),`) ||
+		strings.Contains(name, "//") || strings.Contains(name, "/*") {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || isDelimiter(r) || isBracket(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns g in the syntax of a grammar file, see WriteTo.
+func (g *Grammar) String() string {
+	var sb strings.Builder
+	_, _ = g.WriteTo(&sb)
+	return sb.String()
+}
+
+// WriteTo writes g in the syntax of a grammar file, which parses back to the
+// same grammar. Comments of the source are lost.
+func (g *Grammar) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	if g.start != "" {
+		fmt.Fprintf(&sb, "%%start %s\n\n", g.start)
+	}
+	for i, p := range g.prods {
+		if i != 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(p.String())
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
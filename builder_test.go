@@ -0,0 +1,110 @@
+package sqlgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const roundTripGrammar = `%start stmt
+stmt [2]: select_stmt #select
+| drop_stmt [3]
+| 'DO' "it's" '\\' 'tab\t' [0]
+select_stmt: 'SELECT' 'a' | 'SELECT' 'b' [5]
+drop_stmt: %empty
+`
+
+func TestGrammarRoundTrip(t *testing.T) {
+	g, err := ParseGrammarString(roundTripGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ParseGrammarString(g.String())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, g)
+	}
+	if again.String() != g.String() {
+		t.Errorf("serialization is not stable:\n%s\n%s", g, again)
+	}
+	if again.Start() != "stmt" || !reflect.DeepEqual(again.ProductionMap(), g.ProductionMap()) {
+		t.Errorf("the grammar changed:\n%s", again)
+	}
+	weights := []int{1, 3, 0}
+	for i, alt := range again.Lookup("stmt").Alternatives() {
+		if alt.Weight() != weights[i] {
+			t.Errorf("alternative %d: expect weight %d, get %d", i, weights[i], alt.Weight())
+		}
+	}
+}
+
+func TestGrammarBuilder(t *testing.T) {
+	g, err := ParseGrammarString(roundTripGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := g.String()
+
+	// Remove DROP, as for a server which doesn't support it.
+	b := g.Builder()
+	mustNot := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustNot(b.RemoveAlternative("stmt", "1"))
+	mustNot(b.Remove("drop_stmt"))
+	mustNot(b.ReplaceAlternative("select_stmt", "1",
+		NewAlternative(Symbol{Terminal, "SELECT"}, Symbol{Nonterminal, "field"}).WithLabel("field").WithWeight(2)))
+	mustNot(b.Add("field", NewAlternative(Symbol{Terminal, "x'y\n"})))
+	mustNot(b.AddAlternative("field", NewAlternative()))
+	built, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.String() != original {
+		t.Error("building should not change the original grammar")
+	}
+	parsed, err := ParseGrammarString(built.String())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, built)
+	}
+	if !reflect.DeepEqual(parsed.ProductionMap(), built.ProductionMap()) {
+		t.Errorf("the built grammar doesn't round-trip:\n%s", built)
+	}
+	alts := parsed.Lookup("select_stmt").Alternatives()
+	if alts[1].Label() != "field" || alts[1].Weight() != 2 {
+		t.Errorf("unexpected alternative: %+v", alts[1])
+	}
+	if syms := parsed.Lookup("field").Alternatives()[0].Symbols(); syms[0].Name != "x'y\n" {
+		t.Errorf("unexpected terminal: %q", syms[0].Name)
+	}
+	if strings.Contains(built.String(), "drop_stmt") {
+		t.Errorf("drop_stmt should be removed:\n%s", built)
+	}
+
+	for name, f := range map[string]func(*GrammarBuilder) error{
+		"duplicate":        func(b *GrammarBuilder) error { return b.Add("stmt", NewAlternative()) },
+		"missing":          func(b *GrammarBuilder) error { return b.Replace("nope", NewAlternative()) },
+		"no alternative":   func(b *GrammarBuilder) error { return b.Add("x") },
+		"invalid name":     func(b *GrammarBuilder) error { return b.Add("a b", NewAlternative()) },
+		"invalid symbol":   func(b *GrammarBuilder) error { return b.Add("x", NewAlternative(Symbol{Nonterminal, "a|b"})) },
+		"duplicate label":  func(b *GrammarBuilder) error { return b.AddAlternative("stmt", NewAlternative().WithLabel("select")) },
+		"missing branch":   func(b *GrammarBuilder) error { return b.RemoveAlternative("stmt", "nope") },
+		"last alternative": func(b *GrammarBuilder) error { return b.RemoveAlternative("drop_stmt", "0") },
+	} {
+		if err := f(g.Builder()); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+	b = g.Builder()
+	mustNot(b.Remove("drop_stmt"))
+	if _, err := b.Build(); err == nil {
+		t.Error("expect error for a reference to a removed production")
+	}
+	b = NewGrammarBuilder()
+	b.SetStart("nope")
+	if _, err := b.Build(); err == nil {
+		t.Error("expect error for a missing start production")
+	}
+}
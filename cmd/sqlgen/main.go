@@ -0,0 +1,308 @@
+// Sqlgen generates SQL statements from a yacc-like grammar.
+//
+// Usage:
+//
+//	sqlgen <command> [options]
+//
+// The commands are:
+//
+//	run     generate statements and write them to stdout or a file
+//	fmt     format grammar files canonically
+//	extract write the part of a grammar which some productions reach
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tangenta/sqlgen"
+)
+
+type command struct {
+	name  string
+	short string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{"run", "generate statements and write them to stdout or a file", runCmd},
+	{"fmt", "format grammar files canonically", fmtCmd},
+	{"extract", "write the part of a grammar which some productions reach", extractCmd},
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sqlgen: ")
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	usage()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sqlgen <command> [options]\n\nThe commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-8s%s\n", c.name, c.short)
+	}
+	os.Exit(2)
+}
+
+func runCmd(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	grammar := fs.String("grammar", "", "grammar file (required)")
+	start := fs.String("start", "", "start production (default the %start of the grammar)")
+	n := fs.Int("n", 10, "number of statements")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "seed of the whole run")
+	format := fs.String("format", "text", "output format: text, sql or jsonl")
+	delimiter := fs.String("delimiter", ";", "statement delimiter of the sql format")
+	output := fs.String("o", "", "output file (default stdout)")
+	vary := fs.Bool("vary", false, "vary keyword case, whitespace, comments and quoting")
+	maxDepth := fs.Int("max-depth", sqlgen.DefaultMaxDepth, "bound the depth of derivations, 0 for no bound")
+	maxTokens := fs.Int("max-tokens", sqlgen.DefaultMaxTokens, "bound the tokens of statements, 0 for no bound")
+	size := fs.String("size", "", "aim at statement sizes in tokens as `min-max:weight,...`, and report the achieved sizes")
+	profile := fs.String("profile", "", "JSON profile of weights, bounds, quotas and sizes, applied before the other flags")
+	quotas := quotaFlag{}
+	fs.Var(quotas, "quota", "cap a production per statement as `name=n`, repeatable")
+	_ = fs.Parse(args)
+	if *grammar == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	g, err := sqlgen.ParseGrammarFile(*grammar)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *start == "" {
+		if g.Start() == "" {
+			log.Fatalf("%s has no %%start, use -start", *grammar)
+		}
+		*start = g.Start()
+	}
+	gen, err := sqlgen.NewGenerator(g.ProductionMap(), *start, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *vary {
+		gen.Variation = sqlgen.DefaultLexicalVariation()
+	}
+	if *profile != "" {
+		p, err := sqlgen.LoadProfile(*profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := gen.ApplyProfile(p); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Only the flags which are set override the profile.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-depth":
+			gen.MaxDepth = *maxDepth
+		case "max-tokens":
+			gen.MaxTokens = *maxTokens
+		case "quota":
+			gen.Quotas = quotas
+		case "size":
+			if gen.TargetSize, err = sqlgen.ParseSizeDistribution(*size); err != nil {
+				log.Fatal(err)
+			}
+		}
+	})
+	var histogram *sqlgen.SizeHistogram
+	if gen.TargetSize != nil {
+		histogram = sqlgen.NewSizeHistogram(gen.TargetSize)
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *output != "" {
+		if f, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+		w = f
+	}
+	sink, err := sqlgen.NewSink(*format, w, *delimiter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := 0; i < *n; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := sink.Write(rec); err != nil {
+			log.Fatal(err)
+		}
+		if histogram != nil {
+			histogram.Add(rec.Tokens)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if histogram != nil {
+		fmt.Fprintf(os.Stderr, "statement sizes:\n%s", histogram)
+	}
+}
+
+func fmtCmd(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the files instead of stdout")
+	list := fs.Bool("l", false, "list the files whose formatting differs")
+	bfs := fs.Bool("bfs", false, "sort productions in breadth-first order from the start production")
+	start := fs.String("start", "", "start production of -bfs (default the %start of the grammar)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sqlgen fmt [options] [files]\n\nWithout files, fmt formats stdin to stdout.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	opts := sqlgen.FormatOptions{BreadthFirst: *bfs, Start: *start}
+
+	if fs.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := sqlgen.FormatGrammar(string(src), opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(out)
+		return
+	}
+	for _, path := range fs.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out, err := sqlgen.FormatGrammar(string(src), opts)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if *list && out != string(src) {
+			fmt.Println(path)
+		}
+		if *write {
+			if out == string(src) {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(out), info.Mode().Perm()); err != nil {
+				log.Fatal(err)
+			}
+		} else if !*list {
+			fmt.Print(out)
+		}
+	}
+}
+
+func extractCmd(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	grammar := fs.String("grammar", "", "grammar file (required)")
+	output := fs.String("o", "", "output file (default stdout)")
+	var roots listFlag
+	fs.Var(&roots, "root", "keep the productions which `name` reaches, repeatable (default the %start of the grammar)")
+	stubs := stubFlag{}
+	fs.Var(stubs, "stub", "replace a production by a terminal as `name[=text]`, the name by default, repeatable")
+	_ = fs.Parse(args)
+	if *grammar == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	g, err := sqlgen.ParseGrammarFile(*grammar)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(roots) == 0 {
+		if g.Start() == "" {
+			log.Fatalf("%s has no %%start, use -root", *grammar)
+		}
+		roots = append(roots, g.Start())
+	}
+	sub, err := g.Extract(roots, stubs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		fmt.Print(sub)
+		return
+	}
+	if err := ioutil.WriteFile(*output, []byte(sub.String()), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// quotaFlag collects the values of -quota.
+type quotaFlag map[string]int
+
+func (q quotaFlag) String() string {
+	return fmt.Sprint(map[string]int(q))
+}
+
+func (q quotaFlag) Set(s string) error {
+	i := strings.LastIndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("expect name=n, get '%s'", s)
+	}
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return err
+	}
+	q[s[:i]] = n
+	return nil
+}
+
+// listFlag collects the values of a repeatable flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// stubFlag collects the values of -stub.
+type stubFlag map[string]string
+
+func (s stubFlag) String() string {
+	return fmt.Sprint(map[string]string(s))
+}
+
+func (s stubFlag) Set(v string) error {
+	name, text := v, ""
+	if i := strings.IndexByte(v, '='); i >= 0 {
+		name, text = v[:i], v[i+1:]
+	}
+	if name == "" {
+		return fmt.Errorf("expect name[=text], get '%s'", v)
+	}
+	s[name] = text
+	return nil
+}
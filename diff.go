@@ -0,0 +1,389 @@
+package sqlgen
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffTester sends every generated statement to two databases and records
+// the statements on which their results diverge.
+type DiffTester struct {
+	A, B *sql.DB
+	// Source produces the statement for a seed. The same seed must always
+	// produce the same statement so that a divergence can be reproduced.
+	Source func(seed int64) string
+	// FloatTolerance is the relative difference under which two values of a
+	// FLOAT or DOUBLE column are considered equal. Other values must be equal
+	// exactly.
+	FloatTolerance float64
+	// Log receives every divergence as a JSON line if it is not nil.
+	Log io.Writer
+}
+
+// Divergence is a statement on which the two databases disagree.
+type Divergence struct {
+	Seed   int64        `json:"seed"`
+	Stmt   string       `json:"stmt"`
+	Reason string       `json:"reason"`
+	A      *QueryResult `json:"a"`
+	B      *QueryResult `json:"b"`
+}
+
+// QueryResult is the normalized outcome of a statement on one database.
+type QueryResult struct {
+	Columns []string `json:"columns,omitempty"`
+	// Types are the database type names of the columns, empty where the
+	// driver doesn't report them.
+	Types []string   `json:"types,omitempty"`
+	Rows  [][]string `json:"rows,omitempty"`
+	Err   string     `json:"err,omitempty"`
+}
+
+// WithGlobalSeed adapts the Generate function of a generated package,
+// which draws from the global math/rand source, to DiffTester.Source.
+func WithGlobalSeed(generate func() string) func(seed int64) string {
+	return func(seed int64) string {
+		rand.Seed(seed)
+		return generate()
+	}
+}
+
+// Run executes n statements, deriving the seed of each statement from seed.
+func (d *DiffTester) Run(n int, seed int64) ([]*Divergence, error) {
+	if d.A == nil || d.B == nil || d.Source == nil {
+		return nil, fmt.Errorf("both databases and the statement source are required")
+	}
+	seeds := rand.New(rand.NewSource(seed))
+	var divs []*Divergence
+	for i := 0; i < n; i++ {
+		stmtSeed := seeds.Int63()
+		div := d.Check(stmtSeed, d.Source(stmtSeed))
+		if div == nil {
+			continue
+		}
+		divs = append(divs, div)
+		if d.Log != nil {
+			bs, err := json.Marshal(div)
+			if err != nil {
+				return divs, err
+			}
+			if _, err := d.Log.Write(append(bs, '\n')); err != nil {
+				return divs, err
+			}
+		}
+	}
+	return divs, nil
+}
+
+// Check executes stmt on both databases and returns the divergence, if any.
+func (d *DiffTester) Check(seed int64, stmt string) *Divergence {
+	resA, resB := query(d.A, stmt), query(d.B, stmt)
+	reason := d.compare(stmt, resA, resB)
+	if reason == "" {
+		return nil
+	}
+	return &Divergence{Seed: seed, Stmt: stmt, Reason: reason, A: resA, B: resB}
+}
+
+func query(db *sql.DB, stmt string) *QueryResult {
+	rows, err := db.Query(stmt)
+	if err != nil {
+		return &QueryResult{Err: err.Error()}
+	}
+	defer func() { _ = rows.Close() }()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return &QueryResult{Err: err.Error()}
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return &QueryResult{Err: err.Error()}
+	}
+	res := &QueryResult{Columns: cols}
+	for _, t := range types {
+		res.Types = append(res.Types, t.DatabaseTypeName())
+	}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return &QueryResult{Err: err.Error()}
+		}
+		row := make([]string, len(cols))
+		for i, v := range vals {
+			row[i] = normalizeValue(v)
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return &QueryResult{Err: err.Error()}
+	}
+	return res
+}
+
+// nullValue is the normalized form of SQL NULL, which can't be confused
+// with any string value since strings are never normalized to it.
+const nullValue = "\x00NULL"
+
+func normalizeValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return nullValue
+	case []byte:
+		return string(x)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// compare returns the reason why two results diverge, or "" if they agree.
+// Error messages are not compared since they differ between engines. Rows
+// are compared in order only where stmt defines it: rows which tie on the
+// ORDER BY keys may come in any order, and if a key isn't a column of the
+// result, the order isn't compared at all.
+func (d *DiffTester) compare(stmt string, a, b *QueryResult) string {
+	if (a.Err == "") != (b.Err == "") {
+		return "only one database returned an error"
+	}
+	if a.Err != "" {
+		return ""
+	}
+	if len(a.Columns) != len(b.Columns) {
+		return fmt.Sprintf("column count %d != %d", len(a.Columns), len(b.Columns))
+	}
+	if len(a.Rows) != len(b.Rows) {
+		return fmt.Sprintf("row count %d != %d", len(a.Rows), len(b.Rows))
+	}
+	floats := make([]bool, len(a.Columns))
+	for i := range floats {
+		floats[i] = isFloatType(a.Types, i) || isFloatType(b.Types, i)
+	}
+	keys, limited, ok := orderKeys(stmt, a.Columns)
+	if !ok {
+		return d.compareRows(0, sortedRows(a.Rows), sortedRows(b.Rows), floats)
+	}
+	// Compare every run of rows which tie on the keys as a set.
+	for start, end := 0, 0; start < len(a.Rows); start = end {
+		for end = start; end < len(a.Rows); end++ {
+			if !d.equalCols(a.Rows[start], a.Rows[end], keys, floats) {
+				break
+			}
+		}
+		for i := start; i < end; i++ {
+			if !d.equalCols(a.Rows[i], b.Rows[i], keys, floats) {
+				return fmt.Sprintf("row %d: order keys %q != %q", i, a.Rows[i], b.Rows[i])
+			}
+		}
+		// A LIMIT may cut the last run at different rows.
+		if limited && end == len(a.Rows) {
+			break
+		}
+		reason := d.compareRows(start, sortedRows(a.Rows[start:end]), sortedRows(b.Rows[start:end]), floats)
+		if reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// compareRows compares rows of the same length cell by cell. The first row
+// is numbered first in the reason.
+func (d *DiffTester) compareRows(first int, rowsA, rowsB [][]string, floats []bool) string {
+	for i := range rowsA {
+		for j := range rowsA[i] {
+			if !d.equalValue(rowsA[i][j], rowsB[i][j], floats[j]) {
+				return fmt.Sprintf("row %d column %d: %q != %q", first+i, j, rowsA[i][j], rowsB[i][j])
+			}
+		}
+	}
+	return ""
+}
+
+func (d *DiffTester) equalCols(a, b []string, cols []int, floats []bool) bool {
+	for _, c := range cols {
+		if !d.equalValue(a[c], b[c], floats[c]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *DiffTester) equalValue(a, b string, float bool) bool {
+	if a == b {
+		return true
+	}
+	if !float || a == nullValue || b == nullValue {
+		return false
+	}
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	diff := math.Abs(fa - fb)
+	scale := math.Max(math.Abs(fa), math.Abs(fb))
+	return diff <= d.FloatTolerance*scale || diff <= d.FloatTolerance
+}
+
+// isFloatType reports whether column i has an approximate numeric type.
+func isFloatType(types []string, i int) bool {
+	if i >= len(types) {
+		return false
+	}
+	switch strings.ToUpper(types[i]) {
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8", "DOUBLE PRECISION":
+		return true
+	}
+	return false
+}
+
+var (
+	orderByRegexp   = regexp.MustCompile(`(?i)^order\s+by\b`)
+	orderEndRegexp  = regexp.MustCompile(`(?i)^(limit|for|lock)\b`)
+	directionRegexp = regexp.MustCompile(`(?i)\s+(asc|desc)$`)
+)
+
+// topLevel calls visit with the index of every byte of stmt outside quotes
+// and parentheses, until visit returns false. Quotes may be escaped by a
+// backslash or by doubling them.
+func topLevel(stmt string, visit func(i int) bool) {
+	depth := 0
+	var q byte
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case q != 0:
+			if c == '\\' && q != '`' {
+				i++
+			} else if c == q {
+				if i+1 < len(stmt) && stmt[i+1] == q {
+					i++
+				} else {
+					q = 0
+				}
+			}
+		case c == '\'' || c == '"' || c == '`':
+			q = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0:
+			if !visit(i) {
+				return
+			}
+		}
+	}
+}
+
+// orderBy returns the index after the ORDER BY of stmt outside parentheses
+// and quotes, or -1 if there is none.
+func orderBy(stmt string) int {
+	ret := -1
+	topLevel(stmt, func(i int) bool {
+		if i == 0 || !isWordByte(stmt[i-1]) {
+			if loc := orderByRegexp.FindStringIndex(stmt[i:]); loc != nil {
+				ret = i + loc[1]
+				return false
+			}
+		}
+		return true
+	})
+	return ret
+}
+
+// isOrdered reports whether the row order of stmt's result is defined,
+// i.e. whether stmt has an ORDER BY outside parentheses and quotes.
+func isOrdered(stmt string) bool {
+	return orderBy(stmt) >= 0
+}
+
+// orderKeys returns the indexes of the columns which stmt orders its result
+// by, and whether a LIMIT follows them. It returns false if stmt has no ORDER
+// BY, or if a key is neither a column name nor a column position.
+func orderKeys(stmt string, cols []string) ([]int, bool, bool) {
+	start := orderBy(stmt)
+	if start < 0 {
+		return nil, false, false
+	}
+	end, limited := len(stmt), false
+	var exprs []string
+	topLevel(stmt, func(i int) bool {
+		switch {
+		case i < start:
+		case stmt[i] == ';':
+			end = i
+			return false
+		case stmt[i] == ',':
+			exprs = append(exprs, stmt[start:i])
+			start = i + 1
+		case !isWordByte(stmt[i-1]):
+			if m := orderEndRegexp.FindString(stmt[i:]); m != "" {
+				end, limited = i, strings.EqualFold(m, "limit")
+				return false
+			}
+		}
+		return true
+	})
+	exprs = append(exprs, stmt[start:end])
+	var keys []int
+	for _, e := range exprs {
+		key := orderColumn(directionRegexp.ReplaceAllString(strings.TrimSpace(e), ""), cols)
+		if key < 0 {
+			return nil, false, false
+		}
+		keys = append(keys, key)
+	}
+	return keys, limited, true
+}
+
+// orderColumn returns the index of the column which the ORDER BY expression
+// e refers to, or -1 if there is none.
+func orderColumn(e string, cols []string) int {
+	if n, err := strconv.Atoi(e); err == nil {
+		if n < 1 || n > len(cols) {
+			return -1
+		}
+		return n - 1
+	}
+	for i, c := range cols {
+		if strings.EqualFold(e, c) {
+			return i
+		}
+	}
+	name := e[strings.LastIndexByte(e, '.')+1:]
+	name = strings.Trim(name, "`")
+	for i, c := range cols {
+		if strings.EqualFold(name, c) {
+			return i
+		}
+	}
+	return -1
+}
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+func sortedRows(rows [][]string) [][]string {
+	ret := append([][]string(nil), rows...)
+	sort.Slice(ret, func(i, j int) bool {
+		return strings.Join(ret[i], "\x00") < strings.Join(ret[j], "\x00")
+	})
+	return ret
+}
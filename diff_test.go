@@ -0,0 +1,165 @@
+package sqlgen
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeDriver answers queries from a fixed table keyed by the statement.
+type fakeDriver map[string]*fakeRows
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn(d), nil }
+
+type fakeConn fakeDriver
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	rows, ok := c[query]
+	if !ok {
+		return nil, fmt.Errorf("unknown statement %q", query)
+	}
+	return fakeStmt{rows}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("not supported") }
+
+type fakeStmt struct{ rows *fakeRows }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return 0 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	r := *s.rows
+	return &r, nil
+}
+
+type fakeRows struct {
+	cols  []string
+	types []string
+	data  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.types) {
+		return r.types[i]
+	}
+	return ""
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+var fakeDBCount int
+
+func openFakeDB(t *testing.T, d fakeDriver) *sql.DB {
+	fakeDBCount++
+	name := fmt.Sprintf("sqlgen-fake-%d", fakeDBCount)
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDiffTester(t *testing.T) {
+	a := openFakeDB(t, fakeDriver{
+		"select a from t":            {cols: []string{"a"}, data: [][]driver.Value{{int64(1)}, {int64(2)}}},
+		"select a from t order by a": {cols: []string{"a"}, data: [][]driver.Value{{int64(1)}, {int64(2)}}},
+		"select avg(a) from t":       {cols: []string{"avg"}, types: []string{"DOUBLE"}, data: [][]driver.Value{{[]byte("1.5000")}}},
+		"select b from t":            {cols: []string{"b"}, data: [][]driver.Value{{nil}}},
+		"select c from t":            {cols: []string{"c"}, types: []string{"BIGINT"}, data: [][]driver.Value{{int64(10000000)}}},
+	})
+	b := openFakeDB(t, fakeDriver{
+		"select a from t":            {cols: []string{"a"}, data: [][]driver.Value{{int64(2)}, {int64(1)}}},
+		"select a from t order by a": {cols: []string{"a"}, data: [][]driver.Value{{int64(2)}, {int64(1)}}},
+		"select avg(a) from t":       {cols: []string{"avg"}, types: []string{"DOUBLE"}, data: [][]driver.Value{{1.5000000001}}},
+		"select c from t":            {cols: []string{"c"}, types: []string{"BIGINT"}, data: [][]driver.Value{{int64(10000001)}}},
+	})
+	stmts := []string{"select a from t", "select a from t order by a", "select avg(a) from t", "select b from t", "select c from t"}
+	var log bytes.Buffer
+	d := &DiffTester{
+		A: a, B: b,
+		Source:         func(seed int64) string { return stmts[int(seed%int64(len(stmts)))] },
+		FloatTolerance: 1e-6,
+		Log:            &log,
+	}
+	divs, err := d.Run(20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, div := range divs {
+		if div.Stmt != d.Source(div.Seed) {
+			t.Errorf("seed %d doesn't reproduce %q", div.Seed, div.Stmt)
+		}
+		found[div.Stmt] = true
+	}
+	if len(found) != 3 || !found["select a from t order by a"] || !found["select b from t"] || !found["select c from t"] {
+		t.Errorf("unexpected divergences: %v", found)
+	}
+	if strings.Count(log.String(), "\n") != len(divs) {
+		t.Errorf("expect %d logged divergences, get:\n%s", len(divs), log.String())
+	}
+}
+
+func TestIsOrdered(t *testing.T) {
+	cases := map[string]bool{
+		"select a from t order by a":                       true,
+		"SELECT a FROM t ORDER\n BY a":                     true,
+		"select a from (select a from t order by a) s":     false,
+		"select 'order by' from t":                         false,
+		"select border by from t":                          false,
+		"select a from t where a in (select 1) order by a": true,
+		`select 'it\'s (' from t order by a`:               true,
+		"select 'it''s (' from t order by a":               true,
+		`select "\" order by a" from t`:                    false,
+	}
+	for stmt, expected := range cases {
+		if isOrdered(stmt) != expected {
+			t.Errorf("isOrdered(%q) should be %v", stmt, expected)
+		}
+	}
+}
+
+func TestCompareOrderTies(t *testing.T) {
+	d := &DiffTester{}
+	cols := []string{"a", "b"}
+	result := func(rows ...[]string) *QueryResult { return &QueryResult{Columns: cols, Rows: rows} }
+	cases := []struct {
+		stmt    string
+		a, b    *QueryResult
+		diverge bool
+	}{
+		{"select a, b from t order by a", result([]string{"1", "x"}, []string{"1", "y"}, []string{"2", "z"}),
+			result([]string{"1", "y"}, []string{"1", "x"}, []string{"2", "z"}), false},
+		{"select a, b from t order by a", result([]string{"1", "x"}, []string{"2", "y"}),
+			result([]string{"2", "y"}, []string{"1", "x"}), true},
+		{"select a, b from t order by t.a desc, 2", result([]string{"1", "x"}, []string{"1", "y"}),
+			result([]string{"1", "y"}, []string{"1", "x"}), true},
+		{"select a, b from t order by a limit 2", result([]string{"1", "x"}, []string{"2", "y"}),
+			result([]string{"1", "x"}, []string{"2", "z"}), false},
+		{"select a, b from t order by a limit 2", result([]string{"1", "x"}, []string{"2", "y"}),
+			result([]string{"1", "z"}, []string{"2", "y"}), true},
+		{"select a, b from t order by rand()", result([]string{"1", "x"}, []string{"2", "y"}),
+			result([]string{"2", "y"}, []string{"1", "x"}), false},
+	}
+	for _, c := range cases {
+		if reason := d.compare(c.stmt, c.a, c.b); (reason != "") != c.diverge {
+			t.Errorf("%q: expect divergence %v, get %q", c.stmt, c.diverge, reason)
+		}
+	}
+}
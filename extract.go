@@ -0,0 +1,58 @@
+package sqlgen
+
+import "fmt"
+
+// Extract returns the standalone grammar of the productions reachable from
+// roots, in the order of g. Every production in stubs is replaced by a single
+// terminal, its placeholder in stubs or else its own name, so that what only
+// it reaches is left out. The %start is kept if it is a root, and is the
+// first root otherwise.
+func (g *Grammar) Extract(roots []string, stubs map[string]string) (*Grammar, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no root production")
+	}
+	prodMap := make(map[string]*Production, len(g.prodMap))
+	for name, p := range g.prodMap {
+		prodMap[name] = p
+	}
+	for name, text := range stubs {
+		if _, ok := g.prodMap[name]; !ok {
+			return nil, fmt.Errorf("stub of unknown production '%s'", name)
+		}
+		if text == "" {
+			text = name
+		}
+		prodMap[name] = &Production{head: name, maxLoop: 1, bodyList: BodyList{
+			{seq: []string{quoteLiteral(text)}, randomFactor: 1},
+		}}
+	}
+
+	reachable := map[string]struct{}{}
+	start := roots[0]
+	for _, root := range roots {
+		if _, ok := g.prodMap[root]; !ok {
+			return nil, fmt.Errorf("root production '%s' not found", root)
+		}
+		if root == g.start {
+			start = root
+		}
+		set, err := breadthFirstSearch(root, prodMap)
+		if err != nil {
+			return nil, err
+		}
+		for name := range set {
+			reachable[name] = struct{}{}
+		}
+	}
+
+	ret := &Grammar{prodMap: map[string]*Production{}, start: start}
+	for _, p := range g.prods {
+		_, ok := reachable[p.head]
+		if _, added := ret.prodMap[p.head]; !ok || added {
+			continue
+		}
+		ret.prods = append(ret.prods, prodMap[p.head])
+		ret.prodMap[p.head] = prodMap[p.head]
+	}
+	return ret, nil
+}
@@ -0,0 +1,64 @@
+package sqlgen
+
+import "testing"
+
+const extractGrammar = `%start stmt
+stmt: create_table_stmt | select_stmt
+create_table_stmt: 'CREATE' 'TABLE' ident '(' column_def ')'
+column_def: ident type
+type: 'INT' | 'VARCHAR' '(' expr ')'
+select_stmt: 'SELECT' expr
+expr: ident | expr '+' expr | '(' select_stmt ')'
+ident: 'a' | 'b'
+`
+
+func TestExtract(t *testing.T) {
+	g, err := ParseGrammarString(extractGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := g.Extract([]string{"create_table_stmt"}, map[string]string{"expr": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `%start create_table_stmt
+
+create_table_stmt: 'CREATE' 'TABLE' ident '(' column_def ')'
+
+column_def: ident type
+
+type: 'INT'
+| 'VARCHAR' '(' expr ')'
+
+expr: '1'
+
+ident: 'a'
+| 'b'
+`
+	if sub.String() != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, sub)
+	}
+	if _, err := ParseGrammarString(sub.String()); err != nil {
+		t.Errorf("the extracted grammar doesn't parse: %v", err)
+	}
+
+	sub, err = g.Extract([]string{"column_def", "stmt"}, map[string]string{"select_stmt": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Start() != "stmt" || sub.Lookup("select_stmt").String() != "select_stmt: 'select_stmt'\n" {
+		t.Errorf("unexpected grammar:\n%s", sub)
+	}
+	if sub.Lookup("expr") == nil {
+		t.Errorf("expect expr, which type reaches, get:\n%s", sub)
+	}
+
+	for _, c := range []struct {
+		roots []string
+		stubs map[string]string
+	}{{nil, nil}, {[]string{"nope"}, nil}, {[]string{"stmt"}, map[string]string{"nope": ""}}} {
+		if _, err := g.Extract(c.roots, c.stubs); err == nil {
+			t.Errorf("expect error for roots %v and stubs %v", c.roots, c.stubs)
+		}
+	}
+}
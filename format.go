@@ -0,0 +1,530 @@
+package sqlgen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FormatOptions tunes FormatGrammar.
+type FormatOptions struct {
+	// BreadthFirst sorts the productions in breadth-first order from Start,
+	// after %start and %token. Unreachable productions come last, in the
+	// order of the source. As %include, %delete, %replace and %extend depend
+	// on what precedes them, they stay in place, and the productions are
+	// sorted between them.
+	BreadthFirst bool
+	// Start is the start of the breadth-first order, the %start of the
+	// grammar by default.
+	Start string
+}
+
+// FormatGrammar returns the grammar src in the canonical layout:
+//
+//	// comment
+//	head [N]: first alternative // trailing comment
+//	| %empty #label [2]         // aligned trailing comment
+//
+// with one blank line between productions, no [1] annotations and no
+// semicolons. Comments are kept next to what they precede or follow.
+func FormatGrammar(src string, opts FormatOptions) (string, error) {
+	parser := NewParser()
+	if _, err := parser.parse(src); err != nil {
+		return "", err
+	}
+	edits, start := parser.edits, parser.Start()
+
+	f := &formatter{}
+	f.scan(src)
+	items := f.items
+	if opts.BreadthFirst {
+		from := opts.Start
+		if from == "" {
+			from = start
+		}
+		if from == "" {
+			return "", fmt.Errorf("no start production to sort from")
+		}
+		var err error
+		if items, err = sortBreadthFirst(items, edits, from); err != nil {
+			return "", err
+		}
+	}
+	out := f.format(items)
+
+	// Formatting must never change the grammar.
+	if _, err := parser.parse(out); err != nil || parser.Start() != start || !sameEdits(edits, parser.edits) {
+		return "", fmt.Errorf("formatting changed the grammar")
+	}
+	return out, nil
+}
+
+// fmtComment is a comment of the source.
+type fmtComment struct {
+	text string
+	// ownLine is true if nothing precedes the comment on its line.
+	ownLine bool
+	// blank is true if a blank line follows the comment.
+	blank bool
+}
+
+type pieceKind int
+
+const (
+	pieceSymbol pieceKind = iota
+	pieceEmpty
+	pieceAnnotation
+	pieceComment
+)
+
+// fmtPiece is a word of an alternative or a directive.
+type fmtPiece struct {
+	kind    pieceKind
+	text    string
+	ownLine bool
+}
+
+type fmtAlt struct {
+	lead     []fmtComment
+	pieces   []fmtPiece
+	trailing string
+}
+
+// fmtItem is a production or a directive, which has a single alternative
+// and no head.
+type fmtItem struct {
+	lead      []fmtComment
+	directive bool
+	// prefix is the %replace or %extend of a production.
+	prefix  string
+	name    string
+	maxLoop string
+	alts    []*fmtAlt
+}
+
+type formatter struct {
+	items  []*fmtItem
+	footer []fmtComment
+
+	pending   []fmtComment
+	item      *fmtItem
+	alt       *fmtAlt
+	prefix    string
+	inHead    bool
+	inBracket bool
+	number    string
+	// parens is the depth of the arguments of a template or an instance.
+	parens int
+}
+
+// fmtToken is a token or, if tok is 0, a comment of the source.
+type fmtToken struct {
+	tok        int
+	text       string
+	start, end int
+}
+
+// scan splits src, which must parse, into items.
+func (f *formatter) scan(src string) {
+	var s Scanner
+	s.reset(src)
+	var toks []fmtToken
+	for {
+		var v yySymType
+		tok := s.Lex(&v)
+		for _, c := range s.comments {
+			text := strings.TrimRightFunc(src[c.start:c.end], unicode.IsSpace)
+			toks = append(toks, fmtToken{text: text, start: c.start, end: c.end})
+		}
+		s.comments = s.comments[:0]
+		if tok == 0 {
+			break
+		}
+		toks = append(toks, fmtToken{tok: tok, text: src[s.startPos:s.curPos], start: s.startPos, end: s.curPos})
+	}
+
+	for i, t := range toks {
+		if t.tok != 0 {
+			f.token(t)
+			continue
+		}
+		next := len(src)
+		if i+1 < len(toks) {
+			next = toks[i+1].start
+		}
+		f.pending = append(f.pending, fmtComment{
+			text:    t.text,
+			ownLine: i == 0 || strings.Contains(src[toks[i-1].end:t.start], "\n"),
+			blank:   strings.Count(src[t.end:next], "\n") >= 2,
+		})
+	}
+	f.endAlt()
+	f.footer = f.pending
+}
+
+func (f *formatter) token(t fmtToken) {
+	switch t.tok {
+	case replaceDirective, extendDirective:
+		f.endAlt()
+		f.prefix = t.text
+	case head, startDirective, tokenDirective, includeDirective, deleteDirective:
+		f.endAlt()
+		f.item = &fmtItem{lead: f.pending, prefix: f.prefix, name: t.text, maxLoop: "1"}
+		f.items = append(f.items, f.item)
+		f.pending, f.alt, f.prefix = nil, nil, ""
+		if t.tok == head {
+			f.inHead = true
+			return
+		}
+		f.item.directive = true
+		f.newAlt()
+		f.addPiece(pieceSymbol, t.text)
+	case Colon:
+		f.item.lead = append(f.item.lead, f.pending...)
+		f.pending, f.inHead = nil, false
+		f.newAlt()
+	case OrBranch:
+		f.endAlt()
+		f.newAlt()
+	case Semicolon:
+	case LeftBr:
+		f.inBracket, f.number = true, ""
+	case RightBr:
+		f.inBracket = false
+		n, _ := strconv.Atoi(f.number)
+		if f.inHead {
+			f.item.maxLoop = strconv.Itoa(n)
+		} else if n != 1 {
+			f.addPiece(pieceAnnotation, "["+strconv.Itoa(n)+"]")
+		}
+	case LeftParen, Comma, RightParen:
+		if t.tok == LeftParen {
+			f.parens++
+		} else if t.tok == RightParen {
+			f.parens--
+		}
+		f.appendText(t.text)
+	case empty:
+		f.addPiece(pieceEmpty, t.text)
+	case label:
+		f.addPiece(pieceAnnotation, t.text)
+	default:
+		if f.inBracket {
+			f.number = t.text
+			return
+		}
+		if f.parens > 0 {
+			f.appendText(t.text)
+			return
+		}
+		f.addPiece(pieceSymbol, t.text)
+	}
+}
+
+// appendText appends the text of an argument list to the last symbol.
+func (f *formatter) appendText(text string) {
+	if f.inHead {
+		f.item.name += text
+		return
+	}
+	last := &f.alt.pieces[len(f.alt.pieces)-1]
+	last.text += text
+}
+
+// newAlt starts an alternative, which the pending comments precede.
+func (f *formatter) newAlt() {
+	f.alt = &fmtAlt{lead: f.pending}
+	f.item.alts = append(f.item.alts, f.alt)
+	f.pending = nil
+}
+
+// addPiece adds a word to the alternative, after the pending comments.
+func (f *formatter) addPiece(kind pieceKind, text string) {
+	for _, c := range f.pending {
+		f.alt.pieces = append(f.alt.pieces, fmtPiece{kind: pieceComment, text: c.text, ownLine: c.ownLine})
+	}
+	f.pending = nil
+	f.alt.pieces = append(f.alt.pieces, fmtPiece{kind: kind, text: text})
+}
+
+// endAlt makes the pending comments on the line of the last alternative its
+// trailing comment. The others remain pending.
+func (f *formatter) endAlt() {
+	if f.alt == nil {
+		return
+	}
+	i := 0
+	for i < len(f.pending) && !f.pending[i].ownLine {
+		i++
+	}
+	texts := make([]string, i)
+	for j, c := range f.pending[:i] {
+		texts[j] = c.text
+	}
+	f.alt.trailing = strings.Join(texts, " ")
+	f.pending = f.pending[i:]
+	f.alt = nil
+}
+
+// fmtLine is a line of output, and its trailing comment.
+type fmtLine struct {
+	text, comment string
+}
+
+func (f *formatter) format(items []*fmtItem) string {
+	var sb strings.Builder
+	writeComments := func(comments []fmtComment, last bool) {
+		for i, c := range comments {
+			sb.WriteString(c.text)
+			sb.WriteString("\n")
+			if c.blank && (last || i != len(comments)-1) {
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	// The comments before the first blank line of the file stay on top.
+	if len(f.items) != 0 {
+		first := f.items[0]
+		for i := len(first.lead) - 1; i >= 0; i-- {
+			if first.lead[i].blank {
+				writeComments(first.lead[:i+1], true)
+				first.lead = first.lead[i+1:]
+				break
+			}
+		}
+	}
+	for i, item := range items {
+		if i != 0 && (!item.directive || !items[i-1].directive || len(item.lead) != 0) {
+			sb.WriteString("\n")
+		}
+		writeComments(item.lead, true)
+		writeLines(&sb, item.lines())
+	}
+	if len(f.footer) != 0 {
+		if len(items) != 0 {
+			sb.WriteString("\n")
+		}
+		writeComments(f.footer, false)
+	}
+	return sb.String()
+}
+
+// lines lays out the item, with a continuation line after a line comment
+// and before a comment which starts a line in the source.
+func (item *fmtItem) lines() []fmtLine {
+	var lines []fmtLine
+	for i, alt := range item.alts {
+		for _, c := range alt.lead {
+			lines = append(lines, fmtLine{text: c.text})
+		}
+		prefix := "|"
+		if item.directive {
+			prefix = ""
+		} else if i == 0 {
+			prefix = item.name
+			if item.prefix != "" {
+				prefix = item.prefix + " " + prefix
+			}
+			if item.maxLoop != "1" {
+				prefix += " [" + item.maxLoop + "]"
+			}
+			prefix += ":"
+		}
+		text, n, brk := prefix, 0, false
+		for _, p := range item.normalize(alt.pieces) {
+			if n != 0 && (brk || p.kind == pieceComment && p.ownLine) {
+				lines = append(lines, fmtLine{text: text})
+				text, n = " ", 0
+			}
+			if text != "" {
+				text += " "
+			}
+			text += p.text
+			n++
+			brk = p.kind == pieceComment && !strings.HasPrefix(p.text, "/*")
+		}
+		lines = append(lines, fmtLine{text: text, comment: alt.trailing})
+	}
+	return lines
+}
+
+// normalize writes an empty alternative as a single %empty.
+func (item *fmtItem) normalize(pieces []fmtPiece) []fmtPiece {
+	if item.directive {
+		return pieces
+	}
+	hasSymbol := false
+	for _, p := range pieces {
+		hasSymbol = hasSymbol || p.kind == pieceSymbol
+	}
+	var ret []fmtPiece
+	hasEmpty := false
+	for _, p := range pieces {
+		if p.kind == pieceEmpty {
+			if hasSymbol || hasEmpty {
+				continue
+			}
+			hasEmpty = true
+		}
+		ret = append(ret, p)
+	}
+	if !hasSymbol && !hasEmpty {
+		ret = append([]fmtPiece{{kind: pieceEmpty, text: "%empty"}}, ret...)
+	}
+	return ret
+}
+
+// writeLines writes lines, aligning the trailing comments of consecutive
+// lines.
+func writeLines(sb *strings.Builder, lines []fmtLine) {
+	for i := 0; i < len(lines); {
+		j, width := i, 0
+		for j < len(lines) && lines[j].comment != "" {
+			if w := utf8.RuneCountInString(lines[j].text); w > width {
+				width = w
+			}
+			j++
+		}
+		if j == i {
+			sb.WriteString(lines[i].text)
+			sb.WriteString("\n")
+			i++
+			continue
+		}
+		for ; i < j; i++ {
+			sb.WriteString(lines[i].text)
+			sb.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(lines[i].text)+1))
+			sb.WriteString(lines[i].comment)
+			sb.WriteString("\n")
+		}
+	}
+}
+
+// sortBreadthFirst moves the directives first, and then the productions in
+// the order breadthFirstSearch visits them from start.
+func sortBreadthFirst(items []*fmtItem, edits []edit, start string) ([]*fmtItem, error) {
+	var prods []*Production
+	index := map[string]int{}
+	for _, e := range edits {
+		if e.op == opInclude || e.op == opDelete {
+			continue
+		}
+		i, ok := index[e.prod.head]
+		if !ok {
+			index[e.prod.head] = len(prods)
+			prods = append(prods, e.prod)
+			continue
+		}
+		// Search the alternatives of %extend too.
+		merged := *e.prod
+		merged.bodyList = append(append(BodyList(nil), prods[i].bodyList...), e.prod.bodyList...)
+		prods[i] = &merged
+	}
+	// Visit the instances of templates, in the place of the templates.
+	prods, err := expandTemplates(prods)
+	if err != nil {
+		return nil, err
+	}
+	prodMap := make(map[string]*Production, len(prods))
+	for _, p := range prods {
+		prodMap[p.head] = p
+	}
+	var order []string
+	_, err = breadthFirstSearch(start, prodMap, func(p *Production) {
+		order = append(order, p.head)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*fmtItem
+	for _, item := range items {
+		if item.directive && item.name == "%start" {
+			ret = append(ret, item)
+		}
+	}
+	// The statements which depend on their position split the others into
+	// segments, which are sorted one by one.
+	var segment []*fmtItem
+	for _, item := range items {
+		switch {
+		case item.directive && item.name == "%start":
+		case item.isPositional():
+			ret = append(ret, sortSegment(segment, order)...)
+			ret = append(ret, item)
+			segment = nil
+		default:
+			segment = append(segment, item)
+		}
+	}
+	return append(ret, sortSegment(segment, order)...), nil
+}
+
+// isPositional reports whether item is an %include, %delete, %replace or
+// %extend, whose meaning depends on the statements before it.
+func (item *fmtItem) isPositional() bool {
+	return item.prefix != "" || item.directive && (item.name == "%include" || item.name == "%delete")
+}
+
+// sortSegment puts the %token directives of items first, then the
+// productions in order, then the others.
+func sortSegment(items []*fmtItem, order []string) []*fmtItem {
+	var ret []*fmtItem
+	done := make(map[*fmtItem]bool, len(items))
+	add := func(ok func(*fmtItem) bool) {
+		for _, item := range items {
+			if !done[item] && ok(item) {
+				ret = append(ret, item)
+				done[item] = true
+			}
+		}
+	}
+	add(func(item *fmtItem) bool { return item.directive })
+	for _, name := range order {
+		add(func(item *fmtItem) bool { return item.name == name })
+		if tmpl, _, ok := splitInstance(name); ok {
+			add(func(item *fmtItem) bool {
+				itemTmpl, _, ok := splitInstance(item.name)
+				return ok && itemTmpl == tmpl
+			})
+		}
+	}
+	add(func(*fmtItem) bool { return true })
+	return ret
+}
+
+// sameEdits reports whether a and b are the same statements in the same
+// order, except that the productions between two other statements may come
+// in any order.
+func sameEdits(a, b []edit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	strs := func(edits []edit) []string {
+		ret := make([]string, len(edits))
+		run := 0
+		for i, e := range edits {
+			ret[i] = e.op.String() + " " + e.path
+			if e.prod != nil {
+				ret[i] += " " + e.prod.String()
+			}
+			if e.op != opDefine {
+				sort.Strings(ret[run:i])
+				run = i + 1
+			}
+		}
+		sort.Strings(ret[run:])
+		return ret
+	}
+	sa, sb := strs(a), strs(b)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package sqlgen
+
+import (
+	"strings"
+	"testing"
+)
+
+const unformattedGrammar = `/* Header. */
+
+// Tables.
+tbl:    'a'   // one
+ |  'b' 'c'  #bc [ 3 ];  // two
+
+%start   stmt
+stmt [1] :   select_stmt
+   |  /* nothing */ |tbl
+select_stmt[5]:'SELECT'  // keyword
+  tbl
+// footer
+`
+
+func TestFormatGrammar(t *testing.T) {
+	expect := `/* Header. */
+
+// Tables.
+tbl: 'a'          // one
+| 'b' 'c' #bc [3] // two
+
+%start stmt
+
+stmt: select_stmt
+| %empty /* nothing */
+| tbl
+
+select_stmt [5]: 'SELECT' // keyword
+  tbl
+
+// footer
+`
+	out, err := FormatGrammar(unformattedGrammar, FormatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, out)
+	}
+	again, err := FormatGrammar(out, FormatOptions{})
+	if err != nil || again != out {
+		t.Errorf("formatting is not stable: %v\n%s", err, again)
+	}
+}
+
+func TestFormatGrammarBreadthFirst(t *testing.T) {
+	out, err := FormatGrammar(unformattedGrammar, FormatOptions{BreadthFirst: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heads []string
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.IndexByte(line, ':'); i > 0 && !strings.HasPrefix(line, "|") {
+			heads = append(heads, line[:i])
+		}
+	}
+	if strings.Join(heads, ",") != "stmt,select_stmt [5],tbl" {
+		t.Errorf("expect stmt, select_stmt and tbl, get %v", heads)
+	}
+	if !strings.HasPrefix(out, "/* Header. */\n\n%start stmt\n\nstmt:") || !strings.Contains(out, "// Tables.\ntbl:") {
+		t.Errorf("comments are misplaced:\n%s", out)
+	}
+
+	out, err = FormatGrammar(unformattedGrammar, FormatOptions{BreadthFirst: true, Start: "tbl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "/* Header. */\n\n%start stmt\n\n// Tables.\ntbl:") {
+		t.Errorf("expect tbl first, get:\n%s", out)
+	}
+
+	if _, err := FormatGrammar("a: 'x'", FormatOptions{BreadthFirst: true}); err == nil {
+		t.Errorf("expect an error without a start production")
+	}
+	if _, err := FormatGrammar("a: 'x' |", FormatOptions{}); err != nil {
+		t.Errorf("expect an empty alternative to format, get %v", err)
+	}
+	if _, err := FormatGrammar("a: 'x' [", FormatOptions{}); err == nil {
+		t.Errorf("expect a syntax error")
+	}
+}
+
+func TestFormatOverlay(t *testing.T) {
+	src := "%include   'base.txt'\n%replace  field :'c'|'d'\n%delete drop_stmt\n%extend stmt[2]:insert_stmt\ninsert_stmt: 'INSERT'\n"
+	expect := `%include 'base.txt'
+
+%replace field: 'c'
+| 'd'
+
+%delete drop_stmt
+
+%extend stmt [2]: insert_stmt
+
+insert_stmt: 'INSERT'
+`
+	out, err := FormatGrammar(src, FormatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, out)
+	}
+}
+
+func TestFormatTemplates(t *testing.T) {
+	src := "%start s\nopt( X )[2] :%empty|X\ns: opt( comma_list( 'a' ) )\ncomma_list(X): X | comma_list(X) ',' X\n"
+	expect := `%start s
+
+s: opt(comma_list('a'))
+
+opt(X) [2]: %empty
+| X
+
+comma_list(X): X
+| comma_list(X) ',' X
+`
+	out, err := FormatGrammar(src, FormatOptions{BreadthFirst: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, out)
+	}
+}
+
+func TestFormatBreadthFirstKeepsPositions(t *testing.T) {
+	src := "%start a\na: b\nb: 'y'\n%delete b\nb: 'z'\n"
+	expect := `%start a
+
+a: b
+
+b: 'y'
+
+%delete b
+
+b: 'z'
+`
+	out, err := FormatGrammar(src, FormatOptions{BreadthFirst: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, out)
+	}
+	if _, err := ParseGrammarString(out); err != nil {
+		t.Errorf("the formatted grammar doesn't load: %v", err)
+	}
+
+	p := NewParser()
+	if _, err := p.parse(src); err != nil {
+		t.Fatal(err)
+	}
+	edits := p.edits
+	moved := []edit{edits[2], edits[0], edits[1], edits[3]}
+	if sameEdits(edits, moved) {
+		t.Error("expect moving the deletion to change the grammar")
+	}
+	if !sameEdits(edits, []edit{edits[1], edits[0], edits[2], edits[3]}) {
+		t.Error("expect the order of productions not to matter")
+	}
+}
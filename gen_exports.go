@@ -0,0 +1,70 @@
+//go:build ignore
+// +build ignore
+
+// Gen_exports writes exports.go, the list of the exported identifiers of
+// sqlgen, which generated packages dot-import and so can't use for the Fn of
+// a production. Run it with go generate after changing the exports.
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	notTest := func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", notTest, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var names []string
+	for _, f := range pkgs["sqlgen"].Files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.IsExported() {
+					names = append(names, d.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					var idents []*ast.Ident
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						idents = append(idents, s.Name)
+					case *ast.ValueSpec:
+						idents = append(idents, s.Names...)
+					}
+					for _, ident := range idents {
+						if ident.IsExported() {
+							names = append(names, ident.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen_exports.go. DO NOT EDIT.\n\npackage sqlgen\n\n")
+	buf.WriteString("// exportedIdents are the exported identifiers of sqlgen.\nvar exportedIdents = []string{\n")
+	for _, name := range names {
+		buf.WriteString("\t\"" + name + "\",\n")
+	}
+	buf.WriteString("}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("exports.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
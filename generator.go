@@ -2,88 +2,184 @@ package sqlgen
 
 import (
 	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-func buildFile(yaccFilePath, prodName, packageName, outputFilePath string) {
-	yaccFilePath = explicitPath(yaccFilePath)
-	outputFilePath = explicitPath(outputFilePath)
-	prods, err := ParseYacc(yaccFilePath)
+// buildFile generates the package packageName under outputFilePath. The
+// package directory may exist already: only the generated files whose
+// content changed are rewritten, the generated files of an earlier run which
+// are not generated anymore are removed, the test file is created only when
+// it is missing, and any other file is left untouched.
+func buildFile(yaccFilePath, prodName, packageName, outputFilePath string) error {
+	g, err := ParseGrammarFile(yaccFilePath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	prodMap := BuildProdMap(prods)
-
-	Must(os.Chdir(outputFilePath))
-	Must(os.Mkdir(packageName, 0755))
-	Must(os.Chdir(packageName))
-	oFile, err := os.OpenFile(prodName + ".go", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	defer func() { _ = oFile.Close() }()
+	if prodName == "" {
+		if g.Start() == "" {
+			return fmt.Errorf("%s: no start production, use %%start or name one", yaccFilePath)
+		}
+		prodName = g.Start()
+	}
+	prodMap := g.ProductionMap()
+	allProds, err := breadthFirstSearch(prodName, prodMap)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	names := make([]string, 0, len(allProds))
+	for p := range allProds {
+		names = append(names, p)
+	}
+	idents := newIdentMap(names)
+
+	var prodFile strings.Builder
+	prodFile.WriteString(packageDirective(packageName))
+	prodFile.WriteString(importDirective)
+	prodFile.WriteString(generateDirective)
+	prodFile.WriteString("\nfunc generate() func() string {")
+	prodFile.WriteString(pubInterface(prodName, idents.goIdent(prodName)))
+	visitor := func(p *Production) {
+		prodFile.WriteString(convertProdToCode(p, idents))
+	}
+	if _, err := breadthFirstSearch(prodName, prodMap, visitor); err != nil {
+		return err
+	}
+	prodFile.WriteString("\n\treturn retFn\n}\n")
 
-	MustWrite(oFile, packageDirective())
-	MustWrite(oFile, importDirective)
-
-	MustWrite(oFile, generateDirective)
-	MustWrite(oFile, fmt.Sprintf("\nfunc generate() func()string {"))
-	MustWrite(oFile, pubInterface(yaccFilePath, prodName))
+	var declareFile strings.Builder
+	declareFile.WriteString(packageDirective(packageName))
+	declNames := make([]string, 0, len(names))
+	for _, p := range names {
+		declNames = append(declNames, idents.goIdent(p))
+	}
+	sort.Strings(declNames)
+	for _, p := range declNames {
+		declareFile.WriteString(convertNameToDeclaration(p))
+	}
 
-	visitor := func(p *Production) {
-		MustWrite(oFile, convertProdToCode(p))
+	pkgDir := filepath.Join(outputFilePath, packageName)
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		return err
 	}
-	allProds, err := breadthFirstSearch(prodName, prodMap, visitor)
+	// The file names don't depend on the grammar, so that no production can
+	// collide with them or give a file a suffix such as _test or _linux.
+	generated := map[string]string{
+		"generate.go":     prodFile.String(),
+		"util.go":         packageDirective(packageName) + utilSnippet,
+		"declarations.go": declareFile.String(),
+		"grammar.go":      packageDirective(packageName) + grammarDeclaration(filepath.Base(yaccFilePath), g.String()),
+	}
+	for name, content := range generated {
+		src, err := formatSource(name, generatedHeader+content)
+		if err != nil {
+			return err
+		}
+		if _, err := writeFileIfChanged(filepath.Join(pkgDir, name), src); err != nil {
+			return err
+		}
+	}
+	if err := removeStaleFiles(pkgDir, generated); err != nil {
+		return err
+	}
+	testName := packageName + "_test.go"
+	src, err := formatSource(testName, packageDirective(packageName)+testSnippet)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	MustWrite(oFile, "\n\t return retFn\n}\n")
+	return writeFileIfAbsent(filepath.Join(pkgDir, testName), src)
+}
+
+// generatedHeader marks the generated files as such for tools and linters,
+// see https://golang.org/s/generatedcode.
+const generatedHeader = "// Code generated by sqlgen. DO NOT EDIT.\n\n"
 
-	utilFile, err := os.OpenFile("util.go", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	defer func() { _ = utilFile.Close() }()
+// formatSource makes sure the generated file parses before formatting it
+// like gofmt, so that a broken template or grammar never reaches the disk.
+func formatSource(name, src string) (string, error) {
+	if _, err := parser.ParseFile(token.NewFileSet(), name, src, parser.ParseComments); err != nil {
+		return "", fmt.Errorf("generated invalid code: %v", err)
+	}
+	bs, err := format.Source([]byte(src))
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	MustWrite(utilFile, packageDirective())
-	MustWrite(utilFile, utilSnippet)
+	return string(bs), nil
+}
 
-	declareFile, err := os.OpenFile("declarations.go", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	defer func() { _ = declareFile.Close() }()
+// removeStaleFiles removes the Go files of dir which start with
+// generatedHeader but are not in generated, such as the file of a former
+// start production, which would redeclare what the others declare.
+func removeStaleFiles(dir string, generated map[string]string) error {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	MustWrite(declareFile, packageDirective())
-	for p := range allProds {
-		p = convertHead(p)
-		MustWrite(declareFile, convertNameToDeclaration(p))
+	for _, f := range files {
+		if _, ok := generated[f.Name()]; ok || f.IsDir() || filepath.Ext(f.Name()) != ".go" {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(string(bs), generatedHeader) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	testFile, err := os.OpenFile(packageName + "_test.go", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	defer func() { _ = testFile.Close() }()
-	if err != nil {
-		log.Fatal(err)
+// writeFileIfChanged writes content to path unless the file holds it already,
+// so that the modification time of unchanged files is kept.
+func writeFileIfChanged(path, content string) (bool, error) {
+	old, err := ioutil.ReadFile(path)
+	if err == nil && string(old) == content {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
 	}
-	MustWrite(testFile, packageDirective())
-	MustWrite(testFile, testSnippet)
+	return true, ioutil.WriteFile(path, []byte(content), 0644)
 }
 
-func packageDirective() string {
-	dir, err := os.Getwd()
+// writeFileIfAbsent writes content to path only if the file doesn't exist,
+// since the user owns it once it is created.
+func writeFileIfAbsent(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil
+	}
 	if err != nil {
-		log.Fatal("Cannot get working directory")
+		return err
+	}
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	dirs := strings.Split(dir, "/")
-	packageName := dirs[len(dirs)-1]
+	return err
+}
+
+func packageDirective(packageName string) string {
 	return fmt.Sprintf("package %s\n", packageName)
 }
 
 const importDirective = `
 import (
-	. "github.com/tangenta/sqlgen"
 	"log"
+
+	. "github.com/tangenta/sqlgen"
 )
 `
 
@@ -93,11 +189,11 @@ var Generate = generate()
 
 const utilSnippet = `
 import (
-	. "github.com/tangenta/sqlgen"
 	"log"
 	"math/rand"
-	"strings"
 	"time"
+
+	. "github.com/tangenta/sqlgen"
 )
 
 var state = State{
@@ -116,6 +212,7 @@ type Fn struct {
 	name        string
 	f           func() Result
 	isBranchTag bool // Mark for splitter '|'.
+	isTerminal  bool // Mark for literals, which have no production.
 }
 
 func (fn *Fn) callWithLoc(branchNum, SeqNum int) Result {
@@ -123,6 +220,12 @@ func (fn *Fn) callWithLoc(branchNum, SeqNum int) Result {
 	if fn.isBranchTag {
 		log.Fatal("Cannot call on Branch tag")
 	}
+	if fn.isTerminal {
+		return fn.f()
+	}
+	if state.ExceedsQuota(fn.name) {
+		return Result{Tp: Invalid}
+	}
 
 	choice := Choice{Branch: branchNum, SeqNum: SeqNum}
 	state.Choices = append(state.Choices, choice)
@@ -142,63 +245,151 @@ func (fn *Fn) callWithLoc(branchNum, SeqNum int) Result {
 	return ret
 }
 
-func (fn *Fn) discard() {
-	state.EnsureInitialized()
+// SetQuotas caps the occurrences of productions in one statement. A branch
+// which would exceed a quota is replaced by another one.
+func SetQuotas(quotas map[string]int) {
+	state.Quotas = quotas
+}
 
-	fnName := fn.name
-	state.Counter[fnName] -= 1
-	state.TotalCounter[fnName] -= 1
+// SetMaxDepth bounds the depth of derivations, DefaultMaxDepth by default.
+// Only branches which can finish within the bound are chosen.
+func SetMaxDepth(depth int) {
+	state.MaxDepth = depth
+}
+
+// SetMaxTokens bounds the number of tokens of a statement, DefaultMaxTokens
+// by default. Only branches which can finish within the bound are chosen.
+func SetMaxTokens(tokens int) {
+	state.MaxTokens = tokens
+}
+
+// SetTargetSize makes the sizes of the statements follow d, such as
+// ParseSizeDistribution("10-50:9,500-1000:1"), by steering the choice of
+// branches. An empty d stops aiming at sizes.
+func SetTargetSize(d SizeDistribution) {
+	state.SetTargetSize(d)
+}
+
+// SetVariation varies the layout of the statements with v, see
+// LexicalVariation, or stops varying it if v is nil.
+func SetVariation(v *LexicalVariation) {
+	variation = v
+	variationRand = rand.New(rand.NewSource(rand.Int63()))
 }
 
 // ----- utilities ------
 
 func random(symbols ...Fn) Result {
 	branches := splitBranches(symbols)
-	return randomBranch(branches)
+	candidates := state.BranchCandidates(func(i int) bool {
+		return fits(branches[i])
+	})
+	return randomBranch(branches, candidates)
 }
 
-func randomBranch(branches [][]Fn) Result {
-	branchNum := len(branches)
-	if branchNum <= 0 {
+// randomBranch derives one of the candidate branches, chosen by weight and
+// target size, and keeps their indices so that state.Choices refers to the
+// right branch.
+func randomBranch(branches [][]Fn, candidates []int) Result {
+	if len(candidates) == 0 {
 		return Result{Tp: Invalid}
 	}
-	chosenBranchNum := rand.Intn(branchNum)
+	i := state.ChooseBranch(candidates)
+	chosenBranchNum := candidates[i]
 	chosenBranch := branches[chosenBranchNum]
 
-	var doneF []Fn
-	var resStr strings.Builder
-	for i, f := range chosenBranch {
-		res := f.callWithLoc(chosenBranchNum, i)
+	// Only quotas depend on what an abandoned branch counted.
+	var totalCounter map[string]int
+	if len(state.Quotas) != 0 {
+		totalCounter = make(map[string]int, len(state.TotalCounter))
+		for name, count := range state.TotalCounter {
+			totalCounter[name] = count
+		}
+	}
+	// Reserve the tokens of the following symbols while deriving each one.
+	// Likewise for their expected size if there is a target size.
+	tokenMark, reserved, pending := state.Tokens, minTokens(chosenBranch), expectedSize(chosenBranch)
+	var tokens []string
+	for seqNum, f := range chosenBranch {
+		reserved -= minTokens(chosenBranch[seqNum : seqNum+1])
+		pending -= expectedSize(chosenBranch[seqNum : seqNum+1])
+		state.Reserved += reserved
+		state.Pending += pending
+		res := f.callWithLoc(chosenBranchNum, seqNum)
+		state.Reserved -= reserved
+		state.Pending -= pending
 		switch res.Tp {
 		case PlainString:
-			doneF = append(doneF, f)
-			if i != 0 {
-				resStr.WriteString(" ")
-			}
-			resStr.WriteString(res.Value)
+			tokens = append(tokens, res.Tokens...)
+			state.Tokens = tokenMark + countTokens(tokens)
 		case NonExist:
 			log.Fatalf("Production '%s' not found", f.name)
 		case Invalid:
-			for _, df := range doneF {
-				df.discard()
+			// Forget what the abandoned branch counted.
+			if totalCounter != nil {
+				state.TotalCounter = totalCounter
 			}
-			branches[chosenBranchNum], branches[0] = branches[0], branches[chosenBranchNum]
-			return randomBranch(branches[1:])
+			state.Tokens = tokenMark
+			return randomBranch(branches, append(candidates[:i], candidates[i+1:]...))
 		default:
 			log.Fatalf("Unsupported result type '%v'", res.Tp)
 		}
 	}
-	return Str(resStr.String())
+	return Str(tokens...)
 }
 
+// fits reports whether branch can be derived within the depth and token
+// bounds.
+func fits(branch []Fn) bool {
+	nonterminals, terminals := splitTerminals(branch)
+	return state.FitsDepth(nonterminals) && state.FitsTokens(nonterminals, terminals)
+}
+
+// minTokens returns the least number of tokens which symbols derive.
+func minTokens(symbols []Fn) int {
+	nonterminals, terminals := splitTerminals(symbols)
+	return state.MinTokens(nonterminals) + terminals
+}
+
+// expectedSize returns the expected number of tokens which symbols derive
+// if there is a target size, 0 otherwise.
+func expectedSize(symbols []Fn) float64 {
+	nonterminals, terminals := splitTerminals(symbols)
+	return state.ExpectedSize(nonterminals, terminals)
+}
+
+// splitTerminals returns the names of the nonterminals among symbols, and the
+// number of the nonempty terminals.
+func splitTerminals(symbols []Fn) ([]string, int) {
+	var nonterminals []string
+	terminals := 0
+	for _, f := range symbols {
+		if !f.isTerminal {
+			nonterminals = append(nonterminals, f.name)
+		} else if f.name != "" {
+			terminals++
+		}
+	}
+	return nonterminals, terminals
+}
+
+// countTokens counts the nonempty tokens, like MinDerivation does.
+func countTokens(tokens []string) int {
+	n := 0
+	for _, t := range tokens {
+		if t != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// splitBranches splits symbols on Or. An empty branch derives to nothing.
 func splitBranches(fns []Fn) [][]Fn {
 	var ret [][]Fn
 	var Branch []Fn
 	for _, f := range append(fns, Or) {
 		if f.isBranchTag {
-			if len(Branch) == 0 {
-				log.Fatal("Empty Branch is impossible to split")
-			}
 			ret = append(ret, Branch)
 			Branch = nil
 		} else {
@@ -216,8 +407,8 @@ func findProductionAndUnwrap(name string, prodMap map[string]*Production) *Produ
 	return ret
 }
 
-func initState(bnfFileName string, beginProdName string) {
-	prods, err := ParseYacc(bnfFileName)
+func initState(grammar string, beginProdName string) {
+	prods, err := ParseYaccString(grammar)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -230,18 +421,41 @@ func initState(bnfFileName string, beginProdName string) {
 	state.ProductionMap = prodMap
 	state.CurrentProduction = beginProd
 	state.BeginProductionName = beginProdName
+	state.MinDerivations = ComputeMinDerivations(prodMap)
+	state.MaxDepth = DefaultMaxDepth
+	state.MaxTokens = DefaultMaxTokens
 	rand.Seed(time.Now().UnixNano())
 	state.IsInitialize = true
 }
 
 func constFn(str string) Fn {
-	return Fn{name: str, f: func() Result {
-		return Result{Tp:PlainString, Value: str}
+	return Fn{name: str, isTerminal: true, f: func() Result {
+		return Str(str)
 	}}
 }
 
-func Str(str string) Result {
-	return Result{Tp: PlainString, Value: str}
+// Str is the result of a derivation which yields tokens.
+func Str(tokens ...string) Result {
+	return Result{Tp: PlainString, Tokens: tokens}
+}
+
+// spacing joins the tokens of a statement, see SQLSpacing for how to change
+// the rules for a terminal.
+var spacing = SQLSpacing()
+
+// variation varies the layout of statements with variationRand if it is not
+// nil.
+var (
+	variation     *LexicalVariation
+	variationRand *rand.Rand
+)
+
+// join joins the tokens of a statement.
+func join(tokens []string) string {
+	if variation == nil {
+		return spacing.Join(tokens)
+	}
+	return variation.Join(variationRand, tokens, spacing)
 }
 
 var Or = Fn{isBranchTag: true}
@@ -249,50 +463,63 @@ var Or = Fn{isBranchTag: true}
 `
 
 const templateDriver = `
-	initState("%s", "%s")
-retFn := func() string {
-	res := %s.f()
-	switch res.Tp {
-	case PlainString:
-		return res.Value
-	case Invalid:
-		log.Println("Invalid SQL")
-		return ""
-	case NonExist:
-		log.Fatalf("Production '%%s' not found", %s.name)
-	default:
-		log.Fatalf("Unsupported result type '%%v'", res.Tp)
-	}
-	return "impossible to reach"
-}
-
+	initState(grammar, %q)
+	retFn := func() string {
+		state.ResetCounters()
+		res := %s.f()
+		switch res.Tp {
+		case PlainString:
+			return join(res.Tokens)
+		case Invalid:
+			log.Println("Invalid SQL")
+			return ""
+		case NonExist:
+			log.Fatalf("Production '%%s' not found", %s.name)
+		default:
+			log.Fatalf("Unsupported result type '%%v'", res.Tp)
+		}
+		return "impossible to reach"
+	}
 `
 
-func pubInterface(yaccFilePath, prodName string) string {
-	return fmt.Sprintf(templateDriver, yaccFilePath, prodName, prodName, prodName)
+func pubInterface(prodName, ident string) string {
+	return fmt.Sprintf(templateDriver, prodName, ident, ident)
 }
 
-const templateR = `
-%s = Fn {
-	name: "%s",
-	f: func() Result {
-		return random(%s
-		)
-	},
+// grammarDeclaration embeds the grammar into the generated package, so that
+// the package neither depends on the location of the grammar file nor reads
+// any file at init. The grammar is serialized with its includes resolved,
+// which the comment of the constant tells the reader of the source file.
+func grammarDeclaration(source, grammar string) string {
+	lit := strconv.Quote(grammar)
+	if !strings.ContainsAny(grammar, "`\r") {
+		lit = "`" + grammar + "`"
+	}
+	return fmt.Sprintf("\n// grammar is a normalized rendering of %s, with its includes\n"+
+		"// resolved and without the comments and layout of the source.\nconst grammar = %s\n", source, lit)
 }
+
+const templateR = `
+	%s = Fn{
+		name: %q,
+		f: func() Result {
+			return random(%s
+			)
+		},
+	}
 `
 
 const templateS = `
-%s = Fn {
-	name: "%s",
-	f: func() Result {
-		return Str(%s)
-	},
-}
+	%s = Fn{
+		name: %q,
+		f: func() Result {
+			return Str(%s)
+		},
+	}
 `
 
-func convertProdToCode(p *Production) string {
-	prodHead := convertHead(p.head)
+func convertProdToCode(p *Production, idents identMap) string {
+	prodHead := idents.goIdent(p.head)
 	if len(p.bodyList) == 1 {
 		allLiteral := true
 		seqs := p.bodyList[0].seq
@@ -303,25 +530,28 @@ func convertProdToCode(p *Production) string {
 			}
 		}
 
-		trimmedSeqs := trimmedStrs(seqs)
 		if allLiteral {
-			return fmt.Sprintf(templateS, prodHead, prodHead, strings.Join(trimmedSeqs, " "))
+			quoted := trimmedStrs(seqs)
+			for i, lit := range quoted {
+				quoted[i] = strconv.Quote(lit)
+			}
+			return fmt.Sprintf(templateS, prodHead, p.head, strings.Join(quoted, ", "))
 		}
 	}
 
 	var bodyStr strings.Builder
 	for i, body := range p.bodyList {
 		for _, s := range body.seq {
-			if isLit, ok := literal(s); ok {
-				s = fmt.Sprintf("constFn(\"%s\")", isLit)
+			if lit, ok := literal(s); ok {
+				s = fmt.Sprintf("constFn(%s)", strconv.Quote(lit))
 			} else {
-				s = convertHead(s)
+				s = idents.goIdent(s)
 			}
 			bodyStr.WriteString(s)
 			bodyStr.WriteString(", ")
 		}
 		if i != len(p.bodyList)-1 {
-			bodyStr.WriteString("Or, \n\t\t\t")
+			bodyStr.WriteString("Or,\n\t\t\t\t")
 		}
 	}
 
@@ -338,25 +568,12 @@ func trimmedStrs(origin []string) []string {
 	ret := make([]string, len(origin))
 	for i, s := range origin {
 		if lit, ok := literal(s); ok {
-			ret[i] = fmt.Sprintf("\"%s\"", lit)
+			ret[i] = lit
 		}
 	}
 	return ret
 }
 
-// convertHead to avoid keyword clash.
-func convertHead(str string) string {
-	if strings.HasPrefix(str, "$@") {
-		return "num" + strings.TrimPrefix(str, "$@")
-	}
-
-	switch str {
-	case "type": return "utype"
-	case "%empty": return "empty"
-	default: return str
-	}
-}
-
 func MustWrite(oFile *os.File, str string) {
 	_, err := oFile.WriteString(str)
 	if err != nil {
@@ -370,17 +587,6 @@ func Must(err error) {
 	}
 }
 
-func explicitPath(p string) string {
-	if !strings.ContainsRune(p, os.PathSeparator) {
-		dir, err := os.Getwd()
-		if err != nil {
-			log.Fatal("Unable to get pwd")
-		}
-		return path.Join(dir, p)
-	}
-	return p
-}
-
 const testSnippet = `
 import (
 	"fmt"
@@ -392,4 +598,4 @@ func TestA(t *testing.T) {
 		fmt.Println(Generate())
 	}
 }
-`
\ No newline at end of file
+`
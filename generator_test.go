@@ -1,7 +1,199 @@
 package sqlgen
 
-import "testing"
+import (
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestNewGenerator(t *testing.T) {
-	buildFile("sample_bnf.txt", "start", "sample",".")
+	if err := buildFile("sample_bnf.txt", "start", "sample", "."); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegenerateIntoExistingDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	wd, _ := os.Getwd()
+
+	if err := buildFile("sample_bnf.txt", "start", "sample", dir); err != nil {
+		t.Fatal(err)
+	}
+	pkgDir := filepath.Join(dir, "sample")
+	userFile := filepath.Join(pkgDir, "user.go")
+	testFile := filepath.Join(pkgDir, "sample_test.go")
+	if err := ioutil.WriteFile(userFile, []byte("package sample\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(testFile, []byte("package sample\n// edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	staleFile := filepath.Join(pkgDir, "stale.go")
+	if err := ioutil.WriteFile(staleFile, []byte(generatedHeader+"package sample\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	utilFile := filepath.Join(pkgDir, "util.go")
+	before, _ := os.Stat(utilFile)
+
+	if err := buildFile("sample_bnf.txt", "start", "sample", dir); err != nil {
+		t.Fatal(err)
+	}
+	if now, _ := os.Getwd(); now != wd {
+		t.Errorf("working directory changed to %s", now)
+	}
+	after, _ := os.Stat(utilFile)
+	if !before.ModTime().Equal(after.ModTime()) {
+		t.Error("unchanged util.go is rewritten")
+	}
+	if _, err := os.Stat(staleFile); !os.IsNotExist(err) {
+		t.Errorf("stale generated file is kept: %v", err)
+	}
+	for path, expected := range map[string]string{
+		userFile: "package sample\n",
+		testFile: "package sample\n// edited\n",
+	} {
+		if bs, _ := ioutil.ReadFile(path); string(bs) != expected {
+			t.Errorf("user-owned %s is modified", path)
+		}
+	}
+}
+
+func TestGeneratedPackageEmbedsGrammar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	grammarPath, _ := filepath.Abs("sample_bnf.txt")
+
+	if err := buildFile(grammarPath, "start", "sample", dir); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "sample", "*.go"))
+	for _, f := range files {
+		bs, _ := ioutil.ReadFile(f)
+		if strings.Contains(string(bs), grammarPath) {
+			t.Errorf("%s refers to the grammar file %s", f, grammarPath)
+		}
+	}
+	bs, _ := ioutil.ReadFile(filepath.Join(dir, "sample", "grammar.go"))
+	grammar, _ := ioutil.ReadFile(grammarPath)
+	if !strings.Contains(string(bs), string(grammar)) {
+		t.Errorf("grammar is not embedded:\n%s", bs)
+	}
+}
+
+func TestGeneratedCodeIsFormatted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err := buildFile("sample_bnf.txt", "start", "sample", dir); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "sample", "*.go"))
+	for _, f := range files {
+		bs, _ := ioutil.ReadFile(f)
+		formatted, err := format.Source(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(formatted) != string(bs) {
+			t.Errorf("%s is not gofmt-ed", f)
+		}
+		if !strings.HasSuffix(f, "_test.go") && !strings.HasPrefix(string(bs), generatedHeader) {
+			t.Errorf("%s lacks the generated code header", f)
+		}
+	}
+
+	if _, err := formatSource("broken.go", "package sample\nfunc {"); err == nil {
+		t.Error("expect error for code that doesn't parse")
+	}
+}
+
+func TestGenerateEscapedLiterals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	grammarPath := filepath.Join(dir, "quotes.txt")
+	grammar := "stmt: 'SELECT' str\n| \"SELECT\" '\\'' \"\\\\\" '\"'\n\nstr: '\\'' \"\\\"\" '\\\\'\n"
+	if err := ioutil.WriteFile(grammarPath, []byte(grammar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := buildFile(grammarPath, "stmt", "quotes", dir); err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := ioutil.ReadFile(filepath.Join(dir, "quotes", "generate.go"))
+	for _, expected := range []string{`constFn("'")`, `constFn("\\")`, `constFn("\"")`, `Str("'", "\"", "\\")`} {
+		if !strings.Contains(string(bs), expected) {
+			t.Errorf("expect %s in generated code:\n%s", expected, bs)
+		}
+	}
+}
+
+func TestGenerateDefaultStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	grammarPath := filepath.Join(dir, "grammar.txt")
+	grammar := "%start stmt\nstmt: 'SELECT' field\nfield: 'a' | 'b'\n"
+	if err := ioutil.WriteFile(grammarPath, []byte(grammar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := buildFile(grammarPath, "", "dflt", dir); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, "dflt", "generate.go")); !strings.Contains(string(bs), `initState(grammar, "stmt")`) {
+		t.Errorf("expect the %%start production to start generate.go:\n%s", bs)
+	}
+	if err := ioutil.WriteFile(grammarPath, []byte("stmt: 'SELECT'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildFile(grammarPath, "", "dflt", dir); err == nil {
+		t.Error("expect error without a start production")
+	}
+}
+
+func TestGenerateFileNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	grammarPath := filepath.Join(dir, "names.txt")
+	if err := ioutil.WriteFile(grammarPath, []byte("util: grammar\ngrammar: 'x'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, start := range []string{"util", "grammar"} {
+		if err := buildFile(grammarPath, start, "names", dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "names", "*.go"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if strings.Join(names, " ") != "declarations.go generate.go grammar.go names_test.go util.go" {
+		t.Errorf("unexpected files: %v", names)
+	}
+	if bs, _ := ioutil.ReadFile(filepath.Join(dir, "names", "util.go")); !strings.Contains(string(bs), "func random(") {
+		t.Error("a production overwrites util.go")
+	}
 }
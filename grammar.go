@@ -0,0 +1,89 @@
+package sqlgen
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+// Grammar is a parsed grammar, ready to drive any number of generators.
+type Grammar struct {
+	prods   []*Production
+	prodMap map[string]*Production
+	start   string
+}
+
+// ParseGrammar parses a grammar from r. Unlike BuildProdMap, it reports a
+// missing production as an error. An %include is an error, as there is no
+// file to resolve it against, see ParseGrammarFile and ParseGrammarFS.
+func ParseGrammar(r io.Reader) (*Grammar, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGrammarString(string(src))
+}
+
+// ParseGrammarString is like ParseGrammar but parses the grammar itself.
+func ParseGrammarString(src string) (*Grammar, error) {
+	l := newFileLoader()
+	if err := l.loadString("", src); err != nil {
+		return nil, err
+	}
+	return l.grammar()
+}
+
+// ParseGrammarFile parses the grammar file path. An %include is relative to
+// the including file.
+func ParseGrammarFile(path string) (*Grammar, error) {
+	l := newFileLoader()
+	if err := l.load("", path); err != nil {
+		return nil, err
+	}
+	g, err := l.grammar()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return g, nil
+}
+
+// ParseGrammarFS parses the grammar file name in fsys, such as an embed.FS.
+// An %include is relative to the including file, in fsys.
+func ParseGrammarFS(fsys fs.FS, name string) (*Grammar, error) {
+	l := newFSLoader(fsys)
+	if err := l.load("", name); err != nil {
+		return nil, err
+	}
+	g, err := l.grammar()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return g, nil
+}
+
+// grammar checks and returns the loaded grammar.
+func (l *loader) grammar() (*Grammar, error) {
+	prods, err := l.productions()
+	if err != nil {
+		return nil, err
+	}
+	prodMap, err := buildProdMap(prods)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := prodMap[l.start]; l.start != "" && !ok {
+		return nil, fmt.Errorf("start production '%s' not found", l.start)
+	}
+	return &Grammar{prods: prods, prodMap: prodMap, start: l.start}, nil
+}
+
+// Start returns the production named by %start, or "" if there is none.
+func (g *Grammar) Start() string {
+	return g.start
+}
+
+// ProductionMap returns the productions by name. It must not be modified.
+func (g *Grammar) ProductionMap() map[string]*Production {
+	return g.prodMap
+}
@@ -0,0 +1,40 @@
+package sqlgen
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testGrammar = `%start stmt
+stmt: 'SELECT' field
+field: 'a' | 'b'
+`
+
+func TestParseGrammar(t *testing.T) {
+	fsys := fstest.MapFS{"grammars/test.y": {Data: []byte(testGrammar)}}
+	load := map[string]func() (*Grammar, error){
+		"reader": func() (*Grammar, error) { return ParseGrammar(strings.NewReader(testGrammar)) },
+		"string": func() (*Grammar, error) { return ParseGrammarString(testGrammar) },
+		"fs":     func() (*Grammar, error) { return ParseGrammarFS(fsys, "grammars/test.y") },
+	}
+	for name, f := range load {
+		g, err := f()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if g.Start() != "stmt" || len(g.ProductionMap()) != 2 {
+			t.Errorf("%s: unexpected grammar: %s, %v", name, g.Start(), g.ProductionMap())
+		}
+	}
+
+	if _, err := ParseGrammarFS(fsys, "missing.y"); err == nil {
+		t.Error("expect error for a missing file")
+	}
+	for _, src := range []string{"stmt: 'a' missing", "%start nope\nstmt: 'a'", "stmt: :", "a: b'c'", "a: 'b'c'"} {
+		if _, err := ParseGrammar(strings.NewReader(src)); err == nil {
+			t.Errorf("expect error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package sqlgen
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//go:generate go run gen_exports.go
+
+// reservedIdents can't name the Fn of a production in a generated package:
+// predeclared identifiers, the helpers of utilSnippet and templateDriver, the
+// imported package names, and everything the package dot-imports from sqlgen,
+// which is listed in exports.go.
+var reservedIdents = map[string]struct{}{}
+
+func init() {
+	for _, names := range []string{
+		// Predeclared identifiers.
+		"any bool byte comparable complex64 complex128 error float32 float64 " +
+			"int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr " +
+			"true false iota nil " +
+			"append cap clear close complex copy delete imag len make max min new panic print println real recover",
+		// Identifiers of the generated package and the packages it imports,
+		// and the locals of templateDriver, which would shadow a production.
+		"_ state Fn random randomBranch splitBranches findProductionAndUnwrap initState constFn Str Or " +
+			"Generate generate retFn res grammar spacing SetQuotas SetMaxDepth SetMaxTokens SetTargetSize SetVariation variation variationRand join fits minTokens expectedSize splitTerminals countTokens TestA log rand strings time fmt testing",
+	} {
+		for _, name := range strings.Fields(names) {
+			reservedIdents[name] = struct{}{}
+		}
+	}
+	for _, name := range exportedIdents {
+		reservedIdents[name] = struct{}{}
+	}
+}
+
+// identMap maps the symbols of a grammar to distinct Go identifiers.
+type identMap map[string]string
+
+// newIdentMap maps every name to itself where possible. The other names are
+// sanitized, and get a numeric suffix if the result is taken already, in
+// sorted order so that the mapping is stable across runs.
+func newIdentMap(names []string) identMap {
+	m := identMap{}
+	taken := map[string]struct{}{}
+	var rest []string
+	for _, name := range names {
+		if isFreeIdent(name) {
+			m[name] = name
+			taken[name] = struct{}{}
+		} else {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		candidate := sanitizeIdent(name)
+		ident := candidate
+		for i := 2; ; i++ {
+			if _, ok := taken[ident]; !ok && isFreeIdent(ident) {
+				break
+			}
+			ident = fmt.Sprintf("%s_%d", candidate, i)
+		}
+		m[name] = ident
+		taken[ident] = struct{}{}
+	}
+	return m
+}
+
+// goIdent returns the Go identifier of name.
+func (m identMap) goIdent(name string) string {
+	ident, ok := m[name]
+	if !ok {
+		panic(fmt.Sprintf("Production '%s' has no identifier", name))
+	}
+	return ident
+}
+
+func isFreeIdent(name string) bool {
+	if !token.IsIdentifier(name) {
+		return false
+	}
+	_, reserved := reservedIdents[name]
+	return !reserved
+}
+
+// sanitizeIdent turns name into a valid identifier which is likely free.
+func sanitizeIdent(name string) string {
+	if strings.HasPrefix(name, "$@") {
+		return "num" + replaceInvalidRunes(strings.TrimPrefix(name, "$@"))
+	}
+	ident := replaceInvalidRunes(name)
+	if !isFreeIdent(ident) {
+		ident = "u" + ident
+	}
+	return ident
+}
+
+func replaceInvalidRunes(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,86 @@
+package sqlgen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIdentMap(t *testing.T) {
+	names := []string{"select", "func", "range", "map", "default", "case", "type", "utype",
+		"a.b", "a-b", "a_b", "random", "state", "Or", "Str", "int", "uint", "$@1", "1abc", "-", "stmt"}
+	m := newIdentMap(names)
+	seen := map[string]string{}
+	for _, name := range names {
+		ident := m.goIdent(name)
+		if !isFreeIdent(ident) {
+			t.Errorf("'%s' is mapped to unusable identifier '%s'", name, ident)
+		}
+		if other, ok := seen[ident]; ok {
+			t.Errorf("'%s' and '%s' are both mapped to '%s'", name, other, ident)
+		}
+		seen[ident] = name
+	}
+	for name, expected := range map[string]string{"stmt": "stmt", "a_b": "a_b", "utype": "utype", "type": "utype_2", "$@1": "num1"} {
+		if m.goIdent(name) != expected {
+			t.Errorf("expect '%s' to be mapped to '%s', get '%s'", name, expected, m.goIdent(name))
+		}
+	}
+	// The driver of the start production declares a local res.
+	if ident := newIdentMap([]string{"res"}).goIdent("res"); ident == "res" {
+		t.Error("start production 'res' is shadowed by the driver")
+	}
+	if again := newIdentMap(names); again.goIdent("a.b") != m.goIdent("a.b") || again.goIdent("a-b") != m.goIdent("a-b") {
+		t.Error("mapping is not stable")
+	}
+}
+
+// The generated packages dot-import sqlgen, so none of its exported
+// identifiers can be used for a production. exports.go lists them.
+func TestExportedIdentsUpToDate(t *testing.T) {
+	notTest := func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", notTest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []string
+	for _, f := range pkgs["sqlgen"].Files {
+		for _, decl := range f.Decls {
+			var names []*ast.Ident
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					names = append(names, d.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						names = append(names, s.Name)
+					case *ast.ValueSpec:
+						names = append(names, s.Names...)
+					}
+				}
+			}
+			for _, name := range names {
+				if name.IsExported() {
+					exported = append(exported, name.Name)
+				}
+			}
+		}
+	}
+	sort.Strings(exported)
+	if !reflect.DeepEqual(exported, exportedIdents) {
+		t.Errorf("exports.go is stale, run go generate: expect %v, get %v", exported, exportedIdents)
+	}
+	for _, name := range exportedIdents {
+		if _, ok := reservedIdents[name]; !ok {
+			t.Errorf("exported identifier '%s' is not reserved", name)
+		}
+	}
+}
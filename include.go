@@ -0,0 +1,185 @@
+package sqlgen
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+)
+
+type editOp int
+
+const (
+	// opDefine adds a production, which must not exist.
+	opDefine editOp = iota
+	// opReplace replaces a production with the same head.
+	opReplace
+	// opExtend appends the alternatives of a production to the one with the
+	// same head, which keeps its [N].
+	opExtend
+	// opDelete deletes the production with the head.
+	opDelete
+	// opInclude applies the statements of another file.
+	opInclude
+)
+
+// edit is a statement of a grammar file. Statements apply in order to the
+// productions of the file and of the files it includes, so that an overlay
+// file can include a base grammar and tweak it:
+//
+//	%include "mysql.txt"
+//	%replace select_stmt: 'SELECT' field_list
+//	%extend stmt: | my_stmt
+//	%delete drop_stmt
+type edit struct {
+	op editOp
+	// prod is the production to define, replace or extend with, or only
+	// the head of the one to delete.
+	prod *Production
+	// path is the file of %include, relative to the including file.
+	path string
+}
+
+// applyEdit applies e to prods, except %include, and returns the result.
+func applyEdit(prods []*Production, e edit) ([]*Production, error) {
+	i := 0
+	for i < len(prods) && prods[i].head != e.prod.head {
+		i++
+	}
+	if e.op == opDefine {
+		if i < len(prods) {
+			return nil, fmt.Errorf("production '%s' is defined twice, use %%replace or %%extend", e.prod.head)
+		}
+		return append(prods, e.prod), nil
+	}
+	if i == len(prods) {
+		return nil, fmt.Errorf("%s of unknown production '%s'", e.op, e.prod.head)
+	}
+	ret := append([]*Production(nil), prods...)
+	switch e.op {
+	case opReplace:
+		ret[i] = e.prod
+	case opExtend:
+		extended := &Production{head: prods[i].head, maxLoop: prods[i].maxLoop}
+		extended.bodyList = append(append(BodyList(nil), prods[i].bodyList...), e.prod.bodyList...)
+		if err := checkAlternatives(extended); err != nil {
+			return nil, err
+		}
+		ret[i] = extended
+	case opDelete:
+		ret = append(ret[:i], ret[i+1:]...)
+	}
+	return ret, nil
+}
+
+func (op editOp) String() string {
+	switch op {
+	case opReplace:
+		return "%replace"
+	case opExtend:
+		return "%extend"
+	case opDelete:
+		return "%delete"
+	case opInclude:
+		return "%include"
+	}
+	return "define"
+}
+
+// loader loads a grammar and the files it includes. A file is included at
+// most once, so that including it twice doesn't define its productions
+// twice.
+type loader struct {
+	// resolve returns the path of name, included by the file from, which
+	// is "" for a grammar that isn't in a file.
+	resolve func(from, name string) string
+	read    func(path string) ([]byte, error)
+	seen    map[string]bool
+
+	prods []*Production
+	start string
+}
+
+// newFileLoader loads files of the operating system.
+func newFileLoader() *loader {
+	return &loader{
+		resolve: func(from, name string) string {
+			if from == "" || filepath.IsAbs(name) {
+				return filepath.Clean(name)
+			}
+			return filepath.Join(filepath.Dir(from), name)
+		},
+		read: ioutil.ReadFile,
+		seen: map[string]bool{},
+	}
+}
+
+// newFSLoader loads files of fsys.
+func newFSLoader(fsys fs.FS) *loader {
+	return &loader{
+		resolve: func(from, name string) string {
+			if from == "" {
+				return path.Clean(name)
+			}
+			return path.Join(path.Dir(from), name)
+		},
+		read: func(name string) ([]byte, error) { return fs.ReadFile(fsys, name) },
+		seen: map[string]bool{},
+	}
+}
+
+// load loads the file name included by from.
+func (l *loader) load(from, name string) error {
+	p := l.resolve(from, name)
+	if l.seen[p] {
+		return nil
+	}
+	l.seen[p] = true
+	src, err := l.read(p)
+	if err != nil {
+		return err
+	}
+	return l.loadString(p, string(src))
+}
+
+// loadString loads the grammar src of the file p, or of no file if p is "",
+// which can't include any. The %start of a file overrides the one of the
+// files it includes.
+func (l *loader) loadString(p, src string) error {
+	located := func(err error) error {
+		if p == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	parser := NewParser()
+	if _, err := parser.parse(src); err != nil {
+		return located(err)
+	}
+	start := parser.Start()
+	for _, e := range parser.edits {
+		var err error
+		if e.op == opInclude {
+			if p == "" {
+				return fmt.Errorf("can't %%include \"%s\" without a grammar file", e.path)
+			}
+			if err = l.load(p, e.path); err != nil {
+				return err
+			}
+			continue
+		}
+		if l.prods, err = applyEdit(l.prods, e); err != nil {
+			return located(err)
+		}
+	}
+	if start != "" {
+		l.start = start
+	}
+	return nil
+}
+
+// productions returns the loaded productions, with templates expanded.
+func (l *loader) productions() ([]*Production, error) {
+	return expandTemplates(l.prods)
+}
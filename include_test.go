@@ -0,0 +1,133 @@
+package sqlgen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const baseGrammar = `%start stmt
+stmt: select_stmt | drop_stmt
+select_stmt: 'SELECT' field
+drop_stmt: 'DROP' 'TABLE' 't'
+field: 'a' | 'b'
+`
+
+const overlayGrammar = `%include "base/mysql.txt"
+%include "base/mysql.txt"
+%start query
+query: stmt ';'
+%replace field: 'c'
+%extend stmt: insert_stmt #insert [2]
+%delete drop_stmt
+%extend stmt: 'DO' '1'
+%replace stmt: select_stmt | insert_stmt
+insert_stmt: 'INSERT' 'INTO' 't' 'VALUES' '(' field ')'
+`
+
+func TestGrammarOverlay(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base/mysql.txt": {Data: []byte(baseGrammar)},
+		"team.txt":       {Data: []byte(overlayGrammar)},
+	}
+	g, err := ParseGrammarFS(fsys, "team.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `%start query
+
+stmt: select_stmt
+| insert_stmt
+
+select_stmt: 'SELECT' field
+
+field: 'c'
+
+query: stmt ';'
+
+insert_stmt: 'INSERT' 'INTO' 't' 'VALUES' '(' field ')'
+`
+	if g.String() != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, g)
+	}
+
+	for src, msg := range map[string]string{
+		"%include 'base/mysql.txt'\nfield: 'c'":            "production 'field' is defined twice",
+		"%include 'base/mysql.txt'\n%replace nope: 'c'":    "%replace of unknown production 'nope'",
+		"%include 'base/mysql.txt'\n%delete nope":          "%delete of unknown production 'nope'",
+		"%include 'base/mysql.txt'\n%extend field: 'x' #l": "",
+		"%include 'base/mysql.txt'\n%delete field":         "Production 'field' not found",
+		"%include 'missing.txt'":                           "missing.txt",
+		"%include base/mysql.txt":                          "expect a quoted file name",
+	} {
+		fsys["bad.txt"] = &fstest.MapFile{Data: []byte(src)}
+		_, err := ParseGrammarFS(fsys, "bad.txt")
+		if msg == "" {
+			if err != nil {
+				t.Errorf("%q: %v", src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expect error %q, get %v", src, msg, err)
+		}
+	}
+}
+
+func TestParseGrammarFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grammar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	if err := os.Mkdir(filepath.Join(dir, "base"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"base/mysql.txt": baseGrammar,
+		"team.txt":       overlayGrammar,
+		"cycle.txt":      "%include 'cycle.txt'\nstmt: 'a'",
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := ParseGrammarFile(filepath.Join(dir, "team.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Start() != "query" || g.Lookup("drop_stmt") != nil || len(g.Productions()) != 5 {
+		t.Errorf("unexpected grammar:\n%s", g)
+	}
+	if _, err := ParseGrammarFile(filepath.Join(dir, "cycle.txt")); err != nil {
+		t.Errorf("expect a file to include itself at most once, get %v", err)
+	}
+
+	if _, _, err := NewParser().ParseAll(overlayGrammar); err == nil {
+		t.Error("expect ParseAll to reject %include")
+	}
+	if _, err := ParseGrammarString(overlayGrammar); err == nil || !strings.Contains(err.Error(), "without a grammar file") {
+		t.Errorf("expect ParseGrammarString to reject %%include, get %v", err)
+	}
+	if _, err := ParseYaccString(overlayGrammar); err == nil {
+		t.Error("expect ParseYaccString to reject %include")
+	}
+	prods, _, err := NewParser().ParseAll("a: 'x'\n%extend a: 'y'\nb: a\n%replace b: 'z'")
+	if err != nil || len(prods) != 2 || len(prods[0].bodyList) != 2 || prods[1].bodyList[0].seq[0] != "'z'" {
+		t.Errorf("unexpected productions %v, %v", prods, err)
+	}
+}
+
+func TestBuildProdMapConflict(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "defined twice") {
+			t.Errorf("expect a panic about the conflict, get %v", r)
+		}
+	}()
+	a := &Production{head: "a", maxLoop: 1, bodyList: BodyList{{seq: []string{"'x'"}, randomFactor: 1}}}
+	BuildProdMap([]*Production{a, a})
+}
@@ -0,0 +1,332 @@
+package sqlgen
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// Generator derives statements directly from a production map, without
+// generating code first.
+type Generator struct {
+	// Spacing joins the tokens of a statement, SQLSpacing by default.
+	Spacing *Spacing
+	// Variation varies the layout of every statement if it is not nil.
+	Variation *LexicalVariation
+	// Quotas caps the occurrences of productions in one statement, and
+	// MaxDepths how deep productions nest in themselves: how many times each
+	// may occur on a path from the root of the derivation tree, such as 2
+	// for a subquery which may contain one more subquery.
+	Quotas    map[string]int
+	MaxDepths map[string]int
+	// MaxDepth bounds the depth of a derivation tree, DefaultMaxDepth by
+	// default, and MaxTokens the number of nonempty tokens of a statement,
+	// DefaultMaxTokens by default. A bound which is not positive means no
+	// bound. Only branches which can still finish within the bounds are
+	// chosen.
+	MaxDepth  int
+	MaxTokens int
+	// TargetSize is the distribution of statement sizes to aim at. A size is
+	// drawn for every statement, and the weight of a branch is scaled by how
+	// close its expected size is to what remains of the target.
+	TargetSize SizeDistribution
+
+	prodMap map[string]*Production
+	start   string
+	seeds   *rand.Rand
+	rand    *rand.Rand
+
+	path    []Step
+	covered map[Step]struct{}
+	total   int
+
+	// totalCounter counts the productions of the current statement, and
+	// counted lists them in order so that a branch can be undone.
+	totalCounter map[string]int
+	counted      []string
+	// nesting counts the productions on the path from the root to the
+	// current one.
+	nesting map[string]int
+
+	// mins holds the shortest derivations, and reserved the tokens which
+	// the symbols pending in the current statement need at least.
+	// tokenCount counts the nonempty tokens derived so far, like
+	// MinDerivation does.
+	mins       map[string]MinDerivation
+	reserved   int
+	tokenCount int
+
+	// sizes holds the expected sizes of productions, target the size drawn
+	// for the current statement or -1, and pending the expected size of the
+	// symbols pending in it.
+	sizes   map[string]float64
+	target  int
+	pending float64
+}
+
+// Step is a choice made during a derivation: production and branch index,
+// along with the label of the branch if it has one.
+type Step struct {
+	Production string `json:"production"`
+	Branch     int    `json:"branch"`
+	Label      string `json:"label,omitempty"`
+}
+
+// Record is a generated statement along with what is needed to reproduce it
+// and to see which parts of the grammar it exercised.
+type Record struct {
+	Seed int64  `json:"seed"`
+	SQL  string `json:"sql"`
+	// Path holds the branch chosen at each expanded production, in pre-order.
+	Path []Step `json:"path,omitempty"`
+	// NewBranches holds the branches covered for the first time.
+	NewBranches []Step `json:"new_branches,omitempty"`
+	// Tokens is the number of tokens of the statement.
+	Tokens int `json:"tokens"`
+	// Covered and Total are the covered and overall branch counts so far.
+	Covered int `json:"covered"`
+	Total   int `json:"total"`
+}
+
+// NewGenerator creates a Generator starting from the production named start.
+// Each statement gets its own seed drawn from seed.
+func NewGenerator(prodMap map[string]*Production, start string, seed int64) (*Generator, error) {
+	if _, ok := prodMap[start]; !ok {
+		return nil, fmt.Errorf("begin production '%s' not found", start)
+	}
+	g := &Generator{
+		Spacing:   SQLSpacing(),
+		MaxDepth:  DefaultMaxDepth,
+		MaxTokens: DefaultMaxTokens,
+		prodMap:   prodMap,
+		start:     start,
+		seeds:     rand.New(rand.NewSource(seed)),
+		covered:   map[Step]struct{}{},
+		mins:      ComputeMinDerivations(prodMap),
+	}
+	if _, ok := g.mins[start]; !ok {
+		return nil, fmt.Errorf("begin production '%s' derives no finite statement", start)
+	}
+	reachable, err := breadthFirstSearch(start, prodMap)
+	if err != nil {
+		return nil, err
+	}
+	for name := range reachable {
+		g.total += len(prodMap[name].bodyList)
+	}
+	return g, nil
+}
+
+// Next generates a statement with the next seed.
+func (g *Generator) Next() (*Record, error) {
+	return g.Generate(g.seeds.Int63())
+}
+
+// Generate generates the statement determined by seed.
+func (g *Generator) Generate(seed int64) (*Record, error) {
+	g.rand = rand.New(rand.NewSource(seed))
+	g.path = nil
+	g.totalCounter, g.counted = map[string]int{}, g.counted[:0]
+	g.nesting = map[string]int{}
+	g.reserved, g.pending, g.tokenCount = 0, 0, 0
+	g.target = g.TargetSize.sample(g.rand)
+	if g.target >= 0 && g.sizes == nil {
+		g.sizes = computeExpectedSizes(g.prodMap, g.mins)
+	}
+	if min := g.mins[g.start]; !g.fits(min, g.MaxDepth, 0) {
+		return nil, fmt.Errorf("no statement fits the budget: '%s' needs depth %d and %d tokens",
+			g.start, min.Depth, min.Tokens)
+	}
+	var tokens []string
+	if err := g.derive(g.start, g.MaxDepth, &tokens); err == errQuotaExceeded {
+		return nil, fmt.Errorf("no statement satisfies the quotas and depths with seed %d", seed)
+	} else if err != nil {
+		return nil, err
+	}
+
+	rec := &Record{Seed: seed, Path: g.path, Tokens: g.tokenCount}
+	if g.Variation != nil {
+		rec.SQL = g.Variation.Join(g.rand, tokens, g.Spacing)
+	} else {
+		rec.SQL = g.Spacing.Join(tokens)
+	}
+	for _, step := range g.path {
+		if _, ok := g.covered[step]; !ok {
+			g.covered[step] = struct{}{}
+			rec.NewBranches = append(rec.NewBranches, step)
+		}
+	}
+	rec.Covered, rec.Total = len(g.covered), g.total
+	return rec, nil
+}
+
+// errQuotaExceeded makes derive try another branch, like Invalid does in
+// the generated code. Exceeding a depth of MaxDepths does as well.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// derive derives name within depth levels of the derivation tree.
+func (g *Generator) derive(name string, depth int, tokens *[]string) error {
+	if lit, ok := literal(name); ok {
+		*tokens = append(*tokens, lit)
+		if lit != "" {
+			g.tokenCount++
+		}
+		return nil
+	}
+	prod, ok := g.prodMap[name]
+	if !ok {
+		return fmt.Errorf("production '%s' not found", name)
+	}
+	if quota, ok := g.Quotas[name]; ok && g.totalCounter[name] >= quota {
+		return errQuotaExceeded
+	}
+	if max, ok := g.MaxDepths[name]; ok && g.nesting[name] >= max {
+		return errQuotaExceeded
+	}
+	g.nesting[name]++
+	defer func() { g.nesting[name]-- }()
+	g.totalCounter[name]++
+	g.counted = append(g.counted, name)
+
+	candidates := branchCandidates(prod.bodyList, func(i int) bool {
+		min, ok := minOfSeq(prod.bodyList[i].seq, g.mins)
+		return ok && g.fits(min, depth, g.tokenCount)
+	})
+	for len(candidates) != 0 {
+		i := g.chooseBranch(prod.bodyList, candidates, g.tokenCount)
+		branch := candidates[i]
+		tokenMark, pathMark, countMark, tokenCount := len(*tokens), len(g.path), len(g.counted), g.tokenCount
+		g.path = append(g.path, Step{Production: name, Branch: branch, Label: prod.bodyList[branch].label})
+		err := g.deriveSeq(prod.bodyList[branch].seq, depth-1, tokens)
+		if err != errQuotaExceeded {
+			return err
+		}
+		// Forget the abandoned branch and try another one.
+		*tokens, g.path, g.tokenCount = (*tokens)[:tokenMark], g.path[:pathMark], tokenCount
+		for _, counted := range g.counted[countMark:] {
+			g.totalCounter[counted]--
+		}
+		g.counted = g.counted[:countMark]
+		candidates = append(candidates[:i], candidates[i+1:]...)
+	}
+	return errQuotaExceeded
+}
+
+func (g *Generator) deriveSeq(seq []string, depth int, tokens *[]string) error {
+	// Reserve the tokens of the following symbols while deriving each one.
+	// Likewise for their expected size if there is a target size.
+	rest, _ := minOfSeq(seq, g.mins)
+	reserved := rest.Tokens
+	pending := 0.0
+	if g.target >= 0 {
+		pending = expectedSize(seq, g.sizes)
+	}
+	for _, s := range seq {
+		min, _ := minOfSeq([]string{s}, g.mins)
+		reserved -= min.Tokens
+		if g.target >= 0 {
+			pending -= expectedSize([]string{s}, g.sizes)
+		}
+		g.reserved += reserved
+		g.pending += pending
+		err := g.derive(s, depth, tokens)
+		g.reserved -= reserved
+		g.pending -= pending
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fits reports whether a derivation of min can start at the given depth
+// budget after count nonempty tokens, leaving room for the reserved tokens.
+func (g *Generator) fits(min MinDerivation, depth, count int) bool {
+	if g.MaxDepth > 0 && min.Depth > depth {
+		return false
+	}
+	return g.MaxTokens <= 0 || count+min.Tokens+g.reserved <= g.MaxTokens
+}
+
+// branchCandidates returns the branches which may be chosen among those
+// whose index fits accepts: those with a positive weight, or all of them if
+// there is none.
+func branchCandidates(bodies BodyList, fits func(int) bool) []int {
+	var ret, zero []int
+	for i, b := range bodies {
+		switch {
+		case !fits(i):
+		case weight(b) > 0:
+			ret = append(ret, i)
+		default:
+			zero = append(zero, i)
+		}
+	}
+	if len(ret) == 0 {
+		return zero
+	}
+	return ret
+}
+
+// chooseBranch picks one of candidates, see chooseBranch, and returns its
+// index in candidates. count is the number of nonempty tokens derived so
+// far.
+func (g *Generator) chooseBranch(bodies BodyList, candidates []int, count int) int {
+	if g.target >= 0 {
+		return chooseSizedBranch(g.rand, bodies, candidates, g.sizes, float64(g.target-count)-g.pending)
+	}
+	return chooseBranch(g.rand, bodies, candidates)
+}
+
+// chooseBranch picks one of candidates with probability proportional to its
+// weight, and returns its index in candidates.
+func chooseBranch(r randSource, bodies BodyList, candidates []int) int {
+	sum := 0
+	for _, c := range candidates {
+		sum += weight(bodies[c])
+	}
+	if sum <= 0 {
+		return r.Intn(len(candidates))
+	}
+	n := r.Intn(sum)
+	for i, c := range candidates {
+		if n < weight(bodies[c]) {
+			return i
+		}
+		n -= weight(bodies[c])
+	}
+	return len(candidates) - 1
+}
+
+// chooseSizedBranch is chooseBranch with the weights scaled by sizeFactor,
+// when the branch should derive about remaining tokens.
+func chooseSizedBranch(r randSource, bodies BodyList, candidates []int, sizes map[string]float64, remaining float64) int {
+	weights := make([]float64, len(candidates))
+	sum := 0.0
+	for i, c := range candidates {
+		w := float64(weight(bodies[c]))
+		if w == 0 {
+			w = 1
+		}
+		weights[i] = w * sizeFactor(expectedSize(bodies[c].seq, sizes), remaining)
+		sum += weights[i]
+	}
+	if sum <= 0 {
+		return r.Intn(len(candidates))
+	}
+	n := r.Float64() * sum
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(candidates) - 1
+}
+
+func weight(b Body) int {
+	if b.randomFactor < 0 {
+		return 0
+	}
+	return b.randomFactor
+}
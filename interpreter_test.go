@@ -0,0 +1,283 @@
+package sqlgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func buildTestProdMap(t *testing.T, prodStrs ...string) map[string]*Production {
+	prods, _, err := NewParser().ParseAll(strings.Join(prodStrs, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return BuildProdMap(prods)
+}
+
+func TestGenerator(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' field 'FROM' table\n| 'DELETE' 'FROM' table",
+		"field: 'a'\n| 'b'",
+		"table: 't'",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch rec.SQL {
+		case "SELECT a FROM t", "SELECT b FROM t", "DELETE FROM t":
+		default:
+			t.Fatalf("unexpected statement: %s", rec.SQL)
+		}
+		again, err := gen.Generate(rec.Seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.SQL != rec.SQL {
+			t.Errorf("seed %d generates both '%s' and '%s'", rec.Seed, rec.SQL, again.SQL)
+		}
+		if rec.Path[0].Production != "stmt" {
+			t.Errorf("path should begin with the start production: %v", rec.Path)
+		}
+	}
+	rec, _ := gen.Next()
+	if rec.Total != 5 || rec.Covered != rec.Total {
+		t.Errorf("expect all 5 branches covered, get %d/%d", rec.Covered, rec.Total)
+	}
+}
+
+func TestGeneratorWeight(t *testing.T) {
+	prodMap := buildTestProdMap(t, "stmt: 'a' [0]\n| 'b' [3]")
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if rec, _ := gen.Next(); rec.SQL != "b" {
+			t.Fatalf("branch with weight 0 is chosen")
+		}
+	}
+	if _, err := NewGenerator(prodMap, "nonexistent", 1); err == nil {
+		t.Error("expect error for unknown start production")
+	}
+}
+
+func TestGeneratorEmpty(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'a' opt 'b' opt",
+		"opt: %empty\n| 'x'\n| ''",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		rec, _ := gen.Next()
+		switch rec.SQL {
+		case "a b", "a x b", "a b x", "a x b x":
+		default:
+			t.Fatalf("unexpected statement: '%s'", rec.SQL)
+		}
+	}
+}
+
+func TestGeneratorSpacing(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' call 'FROM' 't' ';'",
+		"call: 'f' '(' 't' '.' 'a' ',' 'b' ')'",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := gen.Next(); rec.SQL != "SELECT f(t.a, b) FROM t;" {
+		t.Errorf("unexpected statement: '%s'", rec.SQL)
+	}
+	gen.Spacing = nil
+	if rec, _ := gen.Next(); rec.SQL != "SELECT f ( t . a , b ) FROM t ;" {
+		t.Errorf("unexpected statement: '%s'", rec.SQL)
+	}
+}
+
+func TestGeneratorVariation(t *testing.T) {
+	prodMap := buildTestProdMap(t, "stmt: 'SELECT' 'a' ',' 'b' 'FROM' 't' 'WHERE' 'c' '=' '1'")
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.Variation = DefaultLexicalVariation()
+	for i := 0; i < 10; i++ {
+		rec, _ := gen.Next()
+		if again, _ := gen.Generate(rec.Seed); again.SQL != rec.SQL {
+			t.Errorf("seed %d generates both %q and %q", rec.Seed, rec.SQL, again.SQL)
+		}
+	}
+}
+
+func TestGeneratorQuotas(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' 'x' 'FROM' table_ref",
+		"table_ref: 't'\n| join_table [3]",
+		"join_table: table_ref 'JOIN' 't'",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.Quotas = map[string]int{"join_table": 3}
+	maxJoins := 0
+	for i := 0; i < 200; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		joins := strings.Count(rec.SQL, "JOIN")
+		if joins > 3 {
+			t.Fatalf("quota is exceeded: %s", rec.SQL)
+		}
+		if joins > maxJoins {
+			maxJoins = joins
+		}
+	}
+	if maxJoins != 3 {
+		t.Errorf("expect statements with 3 joins, get at most %d", maxJoins)
+	}
+
+	gen.Quotas = map[string]int{"join_table": 0, "table_ref": 1}
+	for i := 0; i < 10; i++ {
+		if rec, err := gen.Next(); err != nil || rec.SQL != "SELECT x FROM t" {
+			t.Fatalf("unexpected statement: %v, %v", rec, err)
+		}
+	}
+	gen.Quotas = map[string]int{"table_ref": 0}
+	if _, err := gen.Next(); err == nil {
+		t.Error("expect error when no statement satisfies the quotas")
+	}
+}
+
+func TestGeneratorBudget(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' expr",
+		"expr: '(' expr ')' [9]\n| expr '+' expr [9]\n| 'a'",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.MaxDepth, gen.MaxTokens = 0, 12
+	for i := 0; i < 100; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(strings.Fields(rec.SQL)); n > 12 {
+			t.Fatalf("%d tokens exceed the budget: %s", n, rec.SQL)
+		}
+	}
+
+	gen.MaxDepth, gen.MaxTokens = 4, 0
+	for i := 0; i < 100; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// stmt and the innermost expr take 2 levels, which leaves 2 for
+		// nested parentheses.
+		nesting, maxNesting := 0, 0
+		for _, c := range rec.SQL {
+			switch c {
+			case '(':
+				nesting++
+				if nesting > maxNesting {
+					maxNesting = nesting
+				}
+			case ')':
+				nesting--
+			}
+		}
+		if maxNesting > 2 {
+			t.Fatalf("depth exceeds the budget: %s", rec.SQL)
+		}
+	}
+
+	gen.MaxDepth = 1
+	if _, err := gen.Next(); err == nil {
+		t.Error("expect error when no statement fits the budget")
+	}
+	if _, err := NewGenerator(buildTestProdMap(t, "loop: loop 'x'"), "loop", 1); err == nil {
+		t.Error("expect error for a start production without finite derivation")
+	}
+
+	// Empty tokens don't count, like in MinDerivation.
+	gen, err = NewGenerator(buildTestProdMap(t, "stmt: e e e x", "e: ''", "x: 'a'"), "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.MaxTokens = 1
+	if rec, err := gen.Next(); err != nil || rec.SQL != "a" || rec.Tokens != 1 {
+		t.Errorf("expect a within 1 token, get %v, %v", rec, err)
+	}
+
+	// The default bounds keep a critical recursion small enough.
+	gen, err = NewGenerator(buildTestProdMap(t, "list: list list\n| 'x'"), "list", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if rec, err := gen.Next(); err != nil || rec.Tokens > DefaultMaxTokens {
+			t.Fatalf("expect at most %d tokens, get %v, %v", DefaultMaxTokens, rec, err)
+		}
+	}
+}
+
+func TestGeneratorMaxDepths(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' expr",
+		"expr: '(' expr ')' [9]\n| 'a'",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.MaxDepths = map[string]int{"expr": 3}
+	deepest := 0
+	for i := 0; i < 100; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(rec.SQL, "("); n > deepest {
+			deepest = n
+		}
+	}
+	if deepest != 2 {
+		t.Errorf("expect expr to nest 3 times, so 2 parentheses at most, get %d", deepest)
+	}
+
+	gen.MaxDepths["stmt"] = 0
+	if _, err := gen.Next(); err == nil {
+		t.Error("expect error when the start production may not occur")
+	}
+}
+
+func TestGeneratorLabels(t *testing.T) {
+	prodMap := buildTestProdMap(t, "stmt: 'a' #first\n| 'b'")
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]Step{"a": {"stmt", 0, "first"}, "b": {"stmt", 1, ""}}[rec.SQL]
+		if len(rec.Path) != 1 || rec.Path[0] != expected {
+			t.Errorf("unexpected path of %s: %v", rec.SQL, rec.Path)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package sqlgen
+
+// SymbolKind tells terminals from nonterminals.
+type SymbolKind int
+
+const (
+	// Terminal is a quoted literal, which derives itself.
+	Terminal SymbolKind = iota
+	// Nonterminal refers to a production.
+	Nonterminal
+)
+
+func (k SymbolKind) String() string {
+	if k == Terminal {
+		return "terminal"
+	}
+	return "nonterminal"
+}
+
+// Symbol is an element of an alternative.
+type Symbol struct {
+	Kind SymbolKind
+	// Name is the name of the production of a nonterminal, or the unescaped
+	// text of a terminal.
+	Name string
+}
+
+// String returns the symbol as in a grammar, with terminals quoted.
+func (s Symbol) String() string {
+	if s.Kind == Terminal {
+		return quoteLiteral(s.Name)
+	}
+	return s.Name
+}
+
+func newSymbol(token string) Symbol {
+	if lit, ok := literal(token); ok {
+		return Symbol{Kind: Terminal, Name: lit}
+	}
+	return Symbol{Kind: Nonterminal, Name: token}
+}
+
+// Alternative is a branch of a production.
+type Alternative struct {
+	index int
+	body  Body
+}
+
+// Index returns the position of the alternative in its production.
+func (a Alternative) Index() int {
+	return a.index
+}
+
+// Label returns the #label of the alternative, or "" if it has none.
+func (a Alternative) Label() string {
+	return a.body.label
+}
+
+// Weight returns the [N] weight of the alternative, 1 by default.
+func (a Alternative) Weight() int {
+	return a.body.randomFactor
+}
+
+// Symbols returns the symbols of the alternative, none for an empty one.
+func (a Alternative) Symbols() []Symbol {
+	ret := make([]Symbol, len(a.body.seq))
+	for i, s := range a.body.seq {
+		ret[i] = newSymbol(s)
+	}
+	return ret
+}
+
+// Name returns the head of the production.
+func (p *Production) Name() string {
+	return p.head
+}
+
+// MaxLoop returns the [N] annotation of the head, 1 by default.
+func (p *Production) MaxLoop() int {
+	return p.maxLoop
+}
+
+// Alternatives returns the branches of the production in order.
+func (p *Production) Alternatives() []Alternative {
+	ret := make([]Alternative, len(p.bodyList))
+	for i, b := range p.bodyList {
+		ret[i] = Alternative{index: i, body: b}
+	}
+	return ret
+}
+
+// Productions returns the productions in the order of the source.
+func (g *Grammar) Productions() []*Production {
+	return append([]*Production(nil), g.prods...)
+}
+
+// Lookup returns the production name, or nil if there is none.
+func (g *Grammar) Lookup(name string) *Production {
+	return g.prodMap[name]
+}
+
+// Reachable returns the productions which can be derived from start,
+// including itself, in breadth-first order.
+func (g *Grammar) Reachable(start string) ([]*Production, error) {
+	var ret []*Production
+	_, err := breadthFirstSearch(start, g.prodMap, func(p *Production) {
+		ret = append(ret, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,68 @@
+package sqlgen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGrammarModel(t *testing.T) {
+	g, err := ParseGrammarString(`stmt: 'SELECT' field 'FROM' "t\n" #sel [3]
+| %empty
+field[2]: 'a'
+unused: 'u'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range g.Productions() {
+		names = append(names, p.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"stmt", "field", "unused"}) {
+		t.Errorf("unexpected productions: %v", names)
+	}
+
+	stmt := g.Lookup("stmt")
+	alts := stmt.Alternatives()
+	if len(alts) != 2 || alts[0].Label() != "sel" || alts[0].Weight() != 3 || alts[1].Index() != 1 {
+		t.Fatalf("unexpected alternatives: %+v", alts)
+	}
+	expected := []Symbol{{Terminal, "SELECT"}, {Nonterminal, "field"}, {Terminal, "FROM"}, {Terminal, "t\n"}}
+	if syms := alts[0].Symbols(); !reflect.DeepEqual(syms, expected) {
+		t.Errorf("expect %v, get %v", expected, syms)
+	}
+	if len(alts[1].Symbols()) != 0 {
+		t.Errorf("expect an empty alternative, get %v", alts[1].Symbols())
+	}
+	alts[0].Symbols()[0].Name = "changed"
+	if alts[0].Symbols()[0].Name != "SELECT" {
+		t.Error("the model should be read-only")
+	}
+	if g.Lookup("field").MaxLoop() != 2 || g.Lookup("nope") != nil {
+		t.Error("unexpected lookup")
+	}
+
+	reachable, err := g.Reachable("stmt")
+	if err != nil || len(reachable) != 2 || reachable[1].Name() != "field" {
+		t.Errorf("unexpected reachable productions: %v, %v", reachable, err)
+	}
+	if s := (Symbol{Terminal, "t\n"}).String(); s != `'t\n'` {
+		t.Errorf("unexpected terminal string: %s", s)
+	}
+}
+
+func TestSymbolStringRoundTrip(t *testing.T) {
+	for r := rune(0); r < 0x80; r++ {
+		if r >= ' ' && r < 0x7f && r != '\'' && r != '"' && r != '\\' {
+			continue
+		}
+		sym := Symbol{Terminal, "a" + string(r) + "b"}
+		g, err := ParseGrammarString("s: " + sym.String())
+		if err != nil {
+			t.Errorf("%q: %v", sym.String(), err)
+			continue
+		}
+		if syms := g.Lookup("s").Alternatives()[0].Symbols(); len(syms) != 1 || syms[0] != sym {
+			t.Errorf("expect %q, get %v", sym.Name, syms)
+		}
+	}
+}
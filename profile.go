@@ -0,0 +1,174 @@
+package sqlgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Profile tunes a Generator without touching its grammar, so that a grammar
+// can drive several workloads. It is read from JSON such as
+//
+//	{
+//		"weights": {"stmt": {"0": 5, "ddl": 0}},
+//		"max_depth": 30,
+//		"max_tokens": 200,
+//		"max_depths": {"subquery": 2},
+//		"quotas": {"join_table": 2},
+//		"size": "10-50:9,500-1000:1"
+//	}
+type Profile struct {
+	// Weights overrides the [N] weights of branches, by production and then
+	// by branch label or index. A weight may not be negative.
+	Weights map[string]map[string]int `json:"weights,omitempty"`
+	// MaxDepth and MaxTokens replace the bounds of the generator unless
+	// they are 0. A negative value removes the bound.
+	MaxDepth  int `json:"max_depth,omitempty"`
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// MaxDepths and Quotas replace those of the generator if they are not
+	// nil.
+	MaxDepths map[string]int `json:"max_depths,omitempty"`
+	Quotas    map[string]int `json:"quotas,omitempty"`
+	// Size replaces the target size of the generator if it is not nil.
+	Size SizeDistribution `json:"size,omitempty"`
+}
+
+// LoadProfile reads a profile from a JSON file.
+func LoadProfile(path string) (*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	p, err := ParseProfile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
+
+// ParseProfile reads a profile in JSON from r. Unknown fields are errors,
+// so that a misspelled setting doesn't go unnoticed.
+func ParseProfile(r io.Reader) (*Profile, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	p := &Profile{}
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ApplyProfile makes g generate according to p. The weights apply to a copy
+// of the production map, which may be shared with other generators.
+func (g *Generator) ApplyProfile(p *Profile) error {
+	prodMap, err := p.reweight(g.prodMap)
+	if err != nil {
+		return err
+	}
+	for name := range p.Quotas {
+		if _, ok := g.prodMap[name]; !ok {
+			return fmt.Errorf("quota of unknown production '%s'", name)
+		}
+	}
+	for name, depth := range p.MaxDepths {
+		if _, ok := g.prodMap[name]; !ok {
+			return fmt.Errorf("max depth of unknown production '%s'", name)
+		}
+		if depth < 0 {
+			return fmt.Errorf("negative max depth %d of production '%s'", depth, name)
+		}
+	}
+
+	g.prodMap, g.sizes = prodMap, nil
+	if p.MaxDepth != 0 {
+		g.MaxDepth = p.MaxDepth
+	}
+	if p.MaxTokens != 0 {
+		g.MaxTokens = p.MaxTokens
+	}
+	if p.MaxDepths != nil {
+		g.MaxDepths = p.MaxDepths
+	}
+	if p.Quotas != nil {
+		g.Quotas = p.Quotas
+	}
+	if p.Size != nil {
+		g.TargetSize = p.Size
+	}
+	return nil
+}
+
+// reweight returns prodMap with the weights of p.
+func (p *Profile) reweight(prodMap map[string]*Production) (map[string]*Production, error) {
+	if len(p.Weights) == 0 {
+		return prodMap, nil
+	}
+	ret := make(map[string]*Production, len(prodMap))
+	for name, prod := range prodMap {
+		ret[name] = prod
+	}
+	// Sort for a stable error when there are several mistakes.
+	names := make([]string, 0, len(p.Weights))
+	for name := range p.Weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prod, ok := prodMap[name]
+		if !ok {
+			return nil, fmt.Errorf("weights of unknown production '%s'", name)
+		}
+		copied := *prod
+		copied.bodyList = append(BodyList(nil), prod.bodyList...)
+		for branch, w := range p.Weights[name] {
+			i := findBranch(copied.bodyList, branch)
+			if i < 0 {
+				return nil, fmt.Errorf("production '%s' has no branch '%s'", name, branch)
+			}
+			if w < 0 {
+				return nil, fmt.Errorf("negative weight %d of branch '%s' of production '%s'", w, branch, name)
+			}
+			copied.bodyList[i].randomFactor = w
+		}
+		ret[name] = &copied
+	}
+	return ret, nil
+}
+
+// findBranch returns the index of the branch with the label, or else with
+// the index, and -1 if there is none.
+func findBranch(bodies BodyList, branch string) int {
+	for i, b := range bodies {
+		if b.label != "" && b.label == branch {
+			return i
+		}
+	}
+	i, err := strconv.Atoi(branch)
+	if err != nil || i < 0 || i >= len(bodies) {
+		return -1
+	}
+	return i
+}
+
+// UnmarshalJSON reads the string format of ParseSizeDistribution.
+func (d *SizeDistribution) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseSizeDistribution(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON writes the string format of ParseSizeDistribution.
+func (d SizeDistribution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
@@ -0,0 +1,66 @@
+package sqlgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: select_stmt\n| ddl_stmt #ddl",
+		"select_stmt: 'SELECT' 'a'",
+		"ddl_stmt: 'CREATE' 'TABLE' 't'\n| 'DROP' 'TABLE' 't'",
+	)
+	p, err := ParseProfile(strings.NewReader(`{
+		"weights": {"stmt": {"0": 0, "ddl": 1}, "ddl_stmt": {"0": 0}},
+		"max_depth": 5,
+		"max_depths": {"ddl_stmt": 1},
+		"quotas": {"select_stmt": 1},
+		"size": "3-4"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.ApplyProfile(p); err != nil {
+		t.Fatal(err)
+	}
+	if gen.MaxDepth != 5 || gen.MaxTokens != DefaultMaxTokens || gen.MaxDepths["ddl_stmt"] != 1 ||
+		gen.Quotas["select_stmt"] != 1 || gen.TargetSize.String() != "3-4:1" {
+		t.Errorf("unexpected settings: %d %d %v %v %v", gen.MaxDepth, gen.MaxTokens, gen.MaxDepths, gen.Quotas, gen.TargetSize)
+	}
+	for i := 0; i < 20; i++ {
+		if rec, _ := gen.Next(); rec.SQL != "DROP TABLE t" {
+			t.Fatalf("unexpected statement: %s", rec.SQL)
+		}
+	}
+	if prodMap["stmt"].bodyList[0].randomFactor != 1 {
+		t.Error("the profile should not change the production map")
+	}
+
+	for _, bad := range []string{
+		`{"weights": {"nope": {"0": 1}}}`,
+		`{"weights": {"stmt": {"2": 1}}}`,
+		`{"weights": {"stmt": {"x": 1}}}`,
+		`{"weights": {"stmt": {"ddl": -1}}}`,
+		`{"quotas": {"nope": 1}}`,
+		`{"max_depths": {"nope": 1}}`,
+		`{"max_depths": {"stmt": -1}}`,
+	} {
+		p, err := ParseProfile(strings.NewReader(bad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gen.ApplyProfile(p); err == nil {
+			t.Errorf("expect error for %s", bad)
+		}
+	}
+	for _, bad := range []string{`{"max_dept": 3}`, `{"size": "10-"}`} {
+		if _, err := ParseProfile(strings.NewReader(bad)); err == nil {
+			t.Errorf("expect error for %s", bad)
+		}
+	}
+}
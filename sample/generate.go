@@ -0,0 +1,56 @@
+// Code generated by sqlgen. DO NOT EDIT.
+
+package sample
+
+import (
+	"log"
+
+	. "github.com/tangenta/sqlgen"
+)
+
+var Generate = generate()
+
+func generate() func() string {
+	initState(grammar, "start")
+	retFn := func() string {
+		state.ResetCounters()
+		res := start.f()
+		switch res.Tp {
+		case PlainString:
+			return join(res.Tokens)
+		case Invalid:
+			log.Println("Invalid SQL")
+			return ""
+		case NonExist:
+			log.Fatalf("Production '%s' not found", start.name)
+		default:
+			log.Fatalf("Unsupported result type '%v'", res.Tp)
+		}
+		return "impossible to reach"
+	}
+
+	start = Fn{
+		name: "start",
+		f: func() Result {
+			return random(a, Or,
+				b,
+			)
+		},
+	}
+
+	a = Fn{
+		name: "a",
+		f: func() Result {
+			return Str("A")
+		},
+	}
+
+	b = Fn{
+		name: "b",
+		f: func() Result {
+			return Str("B")
+		},
+	}
+
+	return retFn
+}
@@ -0,0 +1,13 @@
+// Code generated by sqlgen. DO NOT EDIT.
+
+package sample
+
+// grammar is a normalized rendering of sample_bnf.txt, with its includes
+// resolved and without the comments and layout of the source.
+const grammar = `start: a
+| b
+
+a: 'A'
+
+b: 'B'
+`
@@ -0,0 +1,107 @@
+package sqlgen
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sink consumes generated records one at a time, so that a corpus never has
+// to be held in memory.
+type Sink interface {
+	Write(r *Record) error
+	// Close flushes buffered output. It doesn't close the underlying writer.
+	Close() error
+}
+
+// NewSink returns the sink for format, which is one of "text", "sql" and
+// "jsonl". The delimiter is only used by the "sql" format.
+func NewSink(format string, w io.Writer, delimiter string) (Sink, error) {
+	switch format {
+	case "text":
+		return NewTextSink(w), nil
+	case "sql":
+		return NewScriptSink(w, delimiter), nil
+	case "jsonl":
+		return NewJSONLSink(w), nil
+	default:
+		return nil, fmt.Errorf("unknown output format '%s'", format)
+	}
+}
+
+type textSink struct {
+	w *bufio.Writer
+}
+
+// NewTextSink writes one statement per line. Since a varied statement may
+// span several lines, backslashes, newlines and carriage returns are escaped
+// as \\, \n and \r.
+func NewTextSink(w io.Writer) Sink {
+	return &textSink{w: bufio.NewWriter(w)}
+}
+
+var lineEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+func (s *textSink) Write(r *Record) error {
+	if _, err := lineEscaper.WriteString(s.w, r.SQL); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+func (s *textSink) Close() error {
+	return s.w.Flush()
+}
+
+type scriptSink struct {
+	w         *bufio.Writer
+	delimiter string
+}
+
+// NewScriptSink writes a SQL script in which every statement is terminated
+// by delimiter. A delimiter other than ";" is declared with a DELIMITER
+// command first, as the mysql client expects.
+func NewScriptSink(w io.Writer, delimiter string) Sink {
+	if delimiter == "" {
+		delimiter = ";"
+	}
+	s := &scriptSink{w: bufio.NewWriter(w), delimiter: delimiter}
+	if delimiter != ";" {
+		_, _ = s.w.WriteString("DELIMITER " + delimiter + "\n")
+	}
+	return s
+}
+
+func (s *scriptSink) Write(r *Record) error {
+	stmt := strings.TrimRight(r.SQL, " \t\n")
+	if _, err := s.w.WriteString(stmt); err != nil {
+		return err
+	}
+	_, err := s.w.WriteString(s.delimiter + "\n")
+	return err
+}
+
+func (s *scriptSink) Close() error {
+	return s.w.Flush()
+}
+
+type jsonlSink struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSONLSink writes every record as a JSON object on its own line.
+func NewJSONLSink(w io.Writer) Sink {
+	bw := bufio.NewWriter(w)
+	return &jsonlSink{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (s *jsonlSink) Write(r *Record) error {
+	return s.enc.Encode(r)
+}
+
+func (s *jsonlSink) Close() error {
+	return s.w.Flush()
+}
@@ -0,0 +1,63 @@
+package sqlgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSink(t *testing.T) {
+	recs := []*Record{{Seed: 1, SQL: "SELECT 1"}, {Seed: 2, SQL: "SELECT 2"}, {Seed: 3, SQL: "SELECT -- c\r\n'\\'"}}
+	cases := []struct {
+		format    string
+		delimiter string
+		expected  string
+	}{
+		{"text", "", "SELECT 1\nSELECT 2\nSELECT -- c\\r\\n'\\\\'\n"},
+		{"sql", ";", "SELECT 1;\nSELECT 2;\nSELECT -- c\r\n'\\';\n"},
+		{"sql", "//", "DELIMITER //\nSELECT 1//\nSELECT 2//\nSELECT -- c\r\n'\\'//\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		sink, err := NewSink(c.format, &buf, c.delimiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			if err := sink.Write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.expected {
+			t.Errorf("format %s: expect %q, get %q", c.format, c.expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	sink, _ := NewSink("jsonl", &buf, "")
+	for _, r := range recs {
+		_ = sink.Write(r)
+	}
+	_ = sink.Close()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(recs) {
+		t.Fatalf("expect %d lines, get %d", len(recs), len(lines))
+	}
+	for i, l := range lines {
+		var r Record
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Seed != recs[i].Seed || r.SQL != recs[i].SQL {
+			t.Errorf("record %d doesn't round trip: %s", i, l)
+		}
+	}
+
+	if _, err := NewSink("xml", &buf, ""); err == nil {
+		t.Error("expect error for unknown format")
+	}
+}
@@ -0,0 +1,230 @@
+package sqlgen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// SizeRange is a range of statement sizes in tokens, bounds included, with
+// the relative weight of the range in a SizeDistribution.
+type SizeRange struct {
+	Min, Max int
+	Weight   float64
+}
+
+// SizeDistribution is the distribution of statement sizes to aim at.
+type SizeDistribution []SizeRange
+
+// ParseSizeDistribution parses a comma separated list of `min-max:weight`,
+// such as "10-50:9,500-1000:1" for mostly 10-50 tokens and occasionally
+// 500 or more. A single size may replace a range, and the weight defaults
+// to 1.
+func ParseSizeDistribution(s string) (SizeDistribution, error) {
+	var d SizeDistribution
+	for _, part := range strings.Split(s, ",") {
+		r := SizeRange{Weight: 1}
+		bounds := strings.TrimSpace(part)
+		if i := strings.IndexByte(bounds, ':'); i >= 0 {
+			w, err := strconv.ParseFloat(bounds[i+1:], 64)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in '%s'", part)
+			}
+			r.Weight, bounds = w, bounds[:i]
+		}
+		lo, hi := bounds, bounds
+		if i := strings.IndexByte(bounds, '-'); i >= 0 {
+			lo, hi = bounds[:i], bounds[i+1:]
+		}
+		var err1, err2 error
+		r.Min, err1 = strconv.Atoi(lo)
+		r.Max, err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || r.Min < 0 || r.Min > r.Max {
+			return nil, fmt.Errorf("invalid size range in '%s'", part)
+		}
+		d = append(d, r)
+	}
+	return d, nil
+}
+
+func (d SizeDistribution) String() string {
+	parts := make([]string, len(d))
+	for i, r := range d {
+		parts[i] = fmt.Sprintf("%d-%d:%g", r.Min, r.Max, r.Weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+// randSource is what sizes and branches are drawn from: a *rand.Rand, or
+// globalRand.
+type randSource interface {
+	Float64() float64
+	Intn(n int) int
+}
+
+// globalRand draws from the global source of math/rand, like the generated
+// packages do.
+type globalRand struct{}
+
+func (globalRand) Float64() float64 { return rand.Float64() }
+func (globalRand) Intn(n int) int   { return rand.Intn(n) }
+
+// sample draws a size, or returns -1 if d is empty.
+func (d SizeDistribution) sample(r randSource) int {
+	if len(d) == 0 {
+		return -1
+	}
+	sum := 0.0
+	for _, sr := range d {
+		sum += sr.Weight
+	}
+	chosen := d[len(d)-1]
+	n := r.Float64() * sum
+	for _, sr := range d {
+		if n < sr.Weight {
+			chosen = sr
+			break
+		}
+		n -= sr.Weight
+	}
+	return chosen.Min + r.Intn(chosen.Max-chosen.Min+1)
+}
+
+// SizeHistogram counts statements by size, in the ranges of a distribution.
+type SizeHistogram struct {
+	Ranges SizeDistribution
+	Counts []int
+	// Other counts the statements which are in none of the ranges.
+	Other int
+}
+
+// NewSizeHistogram returns an empty histogram of the ranges of d.
+func NewSizeHistogram(d SizeDistribution) *SizeHistogram {
+	return &SizeHistogram{Ranges: d, Counts: make([]int, len(d))}
+}
+
+// Add counts a statement of size tokens.
+func (h *SizeHistogram) Add(tokens int) {
+	for i, r := range h.Ranges {
+		if r.Min <= tokens && tokens <= r.Max {
+			h.Counts[i]++
+			return
+		}
+	}
+	h.Other++
+}
+
+func (h *SizeHistogram) String() string {
+	total := h.Other
+	for _, c := range h.Counts {
+		total += c
+	}
+	var sb strings.Builder
+	line := func(label string, count int) {
+		percent := 0.0
+		if total != 0 {
+			percent = 100 * float64(count) / float64(total)
+		}
+		fmt.Fprintf(&sb, "%-12s%8d %6.1f%%\n", label, count, percent)
+	}
+	for i, r := range h.Ranges {
+		line(fmt.Sprintf("%d-%d", r.Min, r.Max), h.Counts[i])
+	}
+	line("other", h.Other)
+	return sb.String()
+}
+
+// maxExpectedSize caps expected sizes. Larger ones are taken as infinite,
+// which happens when recursive branches are likely enough.
+const maxExpectedSize = 1e4
+
+// computeExpectedSizes computes the expected number of tokens derived from
+// every production, when branches are chosen by weight among those with a
+// finite derivation. A production with an infinite expected size counts as
+// the size of its shortest derivation instead, so that its branches can
+// still be told apart.
+func computeExpectedSizes(prodMap map[string]*Production, mins map[string]MinDerivation) map[string]float64 {
+	sizes := make(map[string]float64, len(prodMap))
+	fixed := map[string]bool{}
+	for {
+		// Iterate from 0 upwards; the sizes converge unless they are
+		// infinite, in which case they hit the cap or keep growing.
+		var growing map[string]bool
+		for round := 0; round < 1000; round++ {
+			growing = map[string]bool{}
+			for name, prod := range prodMap {
+				if fixed[name] {
+					continue
+				}
+				candidates := branchCandidates(prod.bodyList, func(i int) bool {
+					_, ok := minOfSeq(prod.bodyList[i].seq, mins)
+					return ok
+				})
+				sum, weights := 0.0, 0.0
+				for _, c := range candidates {
+					w := float64(weight(prod.bodyList[c]))
+					if w == 0 {
+						w = 1
+					}
+					sum += w * expectedSize(prod.bodyList[c].seq, sizes)
+					weights += w
+				}
+				if weights == 0 {
+					continue
+				}
+				size := math.Min(sum/weights, maxExpectedSize)
+				if size-sizes[name] > 1e-9*math.Max(1, size) {
+					growing[name] = true
+				}
+				sizes[name] = size
+			}
+			if len(growing) == 0 {
+				break
+			}
+		}
+
+		diverged := false
+		for name, size := range sizes {
+			if !fixed[name] && (size >= maxExpectedSize || growing[name]) {
+				sizes[name], fixed[name], diverged = float64(mins[name].Tokens), true, true
+			}
+		}
+		if !diverged {
+			return sizes
+		}
+		// Start over with the others, which may depend on the diverged ones.
+		for name := range sizes {
+			if !fixed[name] {
+				delete(sizes, name)
+			}
+		}
+	}
+}
+
+// expectedSize returns the expected number of tokens derived from seq.
+func expectedSize(seq []string, sizes map[string]float64) float64 {
+	ret := 0.0
+	for _, s := range seq {
+		if lit, ok := literal(s); ok {
+			if lit != "" {
+				ret++
+			}
+			continue
+		}
+		ret += sizes[s]
+	}
+	return ret
+}
+
+// sizeFactor scales the weight of a branch which is expected to derive
+// expected tokens, when the production should derive about target tokens.
+// It is 1 on target and falls with the ratio between the two.
+func sizeFactor(expected, target float64) float64 {
+	ratio := (expected + 1) / (math.Max(target, 0) + 1)
+	if ratio > 1 {
+		ratio = 1 / ratio
+	}
+	return math.Pow(ratio, 4)
+}
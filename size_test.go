@@ -0,0 +1,92 @@
+package sqlgen
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseSizeDistribution(t *testing.T) {
+	d, err := ParseSizeDistribution("10-50:9, 500-1000:1,20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.String() != "10-50:9,500-1000:1,20-20:1" {
+		t.Errorf("unexpected distribution: %s", d)
+	}
+	for _, s := range []string{"", "10-", "50-10", "10:x", "10:-1", "-3"} {
+		if _, err := ParseSizeDistribution(s); err == nil {
+			t.Errorf("expect error for '%s'", s)
+		}
+	}
+}
+
+func TestComputeExpectedSizes(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"list: 'a'\n| list ',' 'a'",
+		"pair: list list",
+		"opt: %empty [3]\n| 'x'",
+		"expr: expr '+' expr\n| '(' expr ')'\n| 'a'",
+		"stmt: 'SELECT' expr",
+	)
+	sizes := computeExpectedSizes(prodMap, ComputeMinDerivations(prodMap))
+	// list = (1 + list + 2) / 2, so list = 3. expr is infinite, and counts
+	// as its shortest derivation.
+	expected := map[string]float64{"list": 3, "pair": 6, "opt": 0.25, "expr": 1, "stmt": 2}
+	for name, exp := range expected {
+		if got := sizes[name]; got < exp-1e-6 || got > exp+1e-6 {
+			t.Errorf("%s: expect %g, get %g", name, exp, got)
+		}
+	}
+}
+
+func TestGeneratorTargetSize(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'SELECT' fields 'FROM' 't'",
+		"fields: field\n| fields ',' field",
+		"field: 'a'\n| 'f' '(' field ')'",
+	)
+	gen, err := NewGenerator(prodMap, "stmt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.TargetSize, _ = ParseSizeDistribution("20-40:9,200-300:1")
+	h := NewSizeHistogram(gen.TargetSize)
+	for i := 0; i < 500; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Add(rec.Tokens)
+	}
+	if h.Counts[0] < 300 || h.Counts[1] < 25 {
+		t.Errorf("the sizes miss the target:\n%s", h)
+	}
+	t.Logf("achieved sizes:\n%s", h)
+}
+
+func TestStateChooseBranch(t *testing.T) {
+	prodMap := buildTestProdMap(t,
+		"stmt: 'a' [9]\n| 'b'\n| long",
+		"long: 'x' 'x' 'x' 'x' 'x' 'x' 'x' 'x' 'x' 'x'")
+	s := State{ProductionMap: prodMap, CurrentProduction: prodMap["stmt"], MinDerivations: ComputeMinDerivations(prodMap)}
+	rand.Seed(1)
+	s.ResetCounters()
+	candidates := s.BranchCandidates(func(int) bool { return true })
+	counts := make([]int, 3)
+	for i := 0; i < 1100; i++ {
+		counts[candidates[s.ChooseBranch(candidates)]]++
+	}
+	if counts[0] < 5*counts[1] || counts[0] < 5*counts[2] {
+		t.Errorf("expect branch 0 to be chosen about 9 times as often, get %v", counts)
+	}
+
+	s.SetTargetSize(SizeDistribution{{Min: 10, Max: 10, Weight: 1}})
+	s.ResetCounters()
+	counts = make([]int, 3)
+	for i := 0; i < 1100; i++ {
+		counts[candidates[s.ChooseBranch(candidates)]]++
+	}
+	if counts[2] < 1000 {
+		t.Errorf("expect the long branch to be chosen for 10 tokens, get %v", counts)
+	}
+}
@@ -0,0 +1,65 @@
+package sqlgen
+
+import "strings"
+
+// Spacing decides where whitespace goes when the tokens of a statement are
+// joined. Tokens are separated by a single space unless a rule says otherwise.
+type Spacing struct {
+	// NoSpaceBefore holds the terminals which stick to the previous token.
+	NoSpaceBefore map[string]bool
+	// NoSpaceAfter holds the terminals which stick to the next token.
+	NoSpaceAfter map[string]bool
+	// NoSpaceAfterWord holds the terminals which stick to a previous word
+	// which is not a keyword, or is one of Functions, such as the ( after a
+	// function name, which MySQL reads as a call only without a space in
+	// between.
+	NoSpaceAfterWord map[string]bool
+	// Functions holds the keywords, in upper case, which also name functions.
+	Functions map[string]bool
+}
+
+// SQLSpacing returns the rules followed by hand-written SQL, which turn
+// `COUNT ( t . a , b )` into `COUNT(t.a, b)`.
+func SQLSpacing() *Spacing {
+	s := &Spacing{
+		NoSpaceBefore:    map[string]bool{",": true, ")": true, ".": true, ";": true},
+		NoSpaceAfter:     map[string]bool{"(": true, ".": true},
+		NoSpaceAfterWord: map[string]bool{"(": true},
+		Functions:        map[string]bool{},
+	}
+	for _, name := range strings.Fields("CHAR CONVERT CUME_DIST CURRENT_DATE CURRENT_TIME CURRENT_TIMESTAMP " +
+		"CURRENT_USER DATABASE DENSE_RANK FIRST_VALUE GROUPING IF INSERT LAG LAST_VALUE LEAD LEFT LOCALTIME " +
+		"LOCALTIMESTAMP MOD NTH_VALUE NTILE PERCENT_RANK RANK REPEAT REPLACE RIGHT ROW_NUMBER SCHEMA " +
+		"UTC_DATE UTC_TIME UTC_TIMESTAMP") {
+		s.Functions[name] = true
+	}
+	return s
+}
+
+// Join joins tokens according to the rules. Empty tokens are skipped, and a
+// nil Spacing separates all tokens by a space.
+func (s *Spacing) Join(tokens []string) string {
+	var sb strings.Builder
+	prev := ""
+	for _, t := range tokens {
+		if t == "" {
+			continue
+		}
+		if sb.Len() != 0 && !s.glued(prev, t) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t)
+		prev = t
+	}
+	return sb.String()
+}
+
+// glued reports whether no space goes between prev and next.
+func (s *Spacing) glued(prev, next string) bool {
+	return s != nil && (s.NoSpaceAfter[prev] || s.NoSpaceBefore[next] || s.NoSpaceAfterWord[next] && s.isFunction(prev))
+}
+
+// isFunction reports whether word may name a function.
+func (s *Spacing) isFunction(word string) bool {
+	return isWord(word) && (!IsSQLKeyword(word) || s.Functions[strings.ToUpper(word)])
+}
@@ -0,0 +1,34 @@
+package sqlgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpacing(t *testing.T) {
+	cases := map[string]string{
+		"f ( a , b )":                 "f(a, b)",
+		"SELECT t . c FROM t ;":       "SELECT t.c FROM t;",
+		"SELECT COUNT ( * ) FROM t":   "SELECT COUNT(*) FROM t",
+		"a ( ( b ) )":                 "a((b))",
+		"a IN ( 1 ) + ( 2 )":          "a IN (1) + (2)",
+		"VALUES ( IF ( a , 1 , 2 ) )": "VALUES (IF(a, 1, 2))",
+		"a AND ( b OR c )":            "a AND (b OR c)",
+		"INTERVAL ( 1 ) DAY":          "INTERVAL (1) DAY",
+	}
+	for tokens, expected := range cases {
+		if res := SQLSpacing().Join(strings.Fields(tokens)); res != expected {
+			t.Errorf("expect '%s', get '%s'", expected, res)
+		}
+	}
+
+	s := SQLSpacing()
+	s.NoSpaceAfter["@"] = true
+	if res := s.Join([]string{"SELECT", "", "@", "x", ""}); res != "SELECT @x" {
+		t.Errorf("custom rule is not applied: '%s'", res)
+	}
+	var plain *Spacing
+	if res := plain.Join([]string{"f", "(", "a", ")"}); res != "f ( a )" {
+		t.Errorf("nil spacing should join by spaces: '%s'", res)
+	}
+}
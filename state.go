@@ -17,13 +17,38 @@ const (
 type Result struct {
 	Tp    ResultType
 	Value string
+	// Tokens holds the terminals of a derivation, to be joined by Spacing.
+	Tokens []string
 }
 
 type State struct {
-	Choices           []Choice
+	Choices []Choice
+	// Counter counts the productions on the stack, TotalCounter counts all
+	// of them in the current statement.
 	Counter           map[string]int
 	TotalCounter      map[string]int
 	CurrentProduction *Production
+	// Quotas caps TotalCounter per production.
+	Quotas map[string]int
+	// MaxDepth bounds the depth of Choices, with the help of the shortest
+	// derivations in MinDerivations. A bound which is not positive means no
+	// bound.
+	MaxDepth       int
+	MinDerivations map[string]MinDerivation
+	// MaxTokens bounds the number of tokens of a statement likewise. Tokens
+	// counts the tokens derived so far, and Reserved the least number of
+	// tokens which the pending symbols derive.
+	MaxTokens int
+	Tokens    int
+	Reserved  int
+	// TargetSize is the distribution of statement sizes to aim at, see
+	// SetTargetSize. Target is the size drawn for the current statement or
+	// -1, Sizes holds the expected sizes of productions, and Pending the
+	// expected size of the pending symbols.
+	TargetSize SizeDistribution
+	Target     int
+	Sizes      map[string]float64
+	Pending    float64
 
 	// Unchanged part during generation
 	ProductionMap       map[string]*Production
@@ -95,3 +120,86 @@ func (s *State) EnsureInitialized() *State {
 	}
 	return s
 }
+
+// ResetCounters starts counting for a new statement.
+func (s *State) ResetCounters() {
+	s.Choices = s.Choices[:0]
+	s.Counter = map[string]int{}
+	s.TotalCounter = map[string]int{}
+	s.Tokens, s.Reserved = 0, 0
+	s.Pending = 0
+	s.Target = s.TargetSize.sample(globalRand{})
+}
+
+// SetTargetSize makes the branches be chosen so that the sizes of the
+// statements follow d, like Generator.TargetSize does. An empty d stops
+// aiming at sizes.
+func (s *State) SetTargetSize(d SizeDistribution) {
+	s.TargetSize, s.Target = d, -1
+	if len(d) != 0 && s.Sizes == nil {
+		s.Sizes = computeExpectedSizes(s.ProductionMap, s.MinDerivations)
+	}
+}
+
+// BranchCandidates returns the branches of the current production which may
+// be chosen among those accepted by fits, see ChooseBranch.
+func (s *State) BranchCandidates(fits func(branch int) bool) []int {
+	return branchCandidates(s.CurrentProduction.bodyList, fits)
+}
+
+// ChooseBranch picks one of the candidate branches of the current production
+// from the global source of math/rand, with probability proportional to its
+// weight, scaled toward the target size if there is one. It returns the
+// index of the branch in candidates.
+func (s *State) ChooseBranch(candidates []int) int {
+	bodies := s.CurrentProduction.bodyList
+	if s.Target >= 0 {
+		remaining := float64(s.Target-s.Tokens) - s.Pending
+		return chooseSizedBranch(globalRand{}, bodies, candidates, s.Sizes, remaining)
+	}
+	return chooseBranch(globalRand{}, bodies, candidates)
+}
+
+// ExpectedSize returns the expected number of tokens which the nonterminals
+// and some nonempty terminals derive, or 0 if there is no target size.
+func (s *State) ExpectedSize(nonterminals []string, terminals int) float64 {
+	if s.Target < 0 {
+		return 0
+	}
+	return expectedSize(nonterminals, s.Sizes) + float64(terminals)
+}
+
+// ExceedsQuota reports whether one more occurrence of the production name
+// would exceed its quota.
+func (s *State) ExceedsQuota(name string) bool {
+	quota, ok := s.Quotas[name]
+	return ok && s.TotalCounter[name] >= quota
+}
+
+// FitsDepth reports whether a branch of the current production, which
+// consists of the nonterminals and some terminals, can be derived within
+// MaxDepth.
+func (s *State) FitsDepth(nonterminals []string) bool {
+	if s.MinDerivations == nil {
+		return true
+	}
+	min, ok := minOfSeq(nonterminals, s.MinDerivations)
+	return ok && (s.MaxDepth <= 0 || min.Depth <= s.MaxDepth-len(s.Choices))
+}
+
+// FitsTokens reports whether a branch of the current production, which
+// consists of the nonterminals and some nonempty terminals, can be derived
+// within MaxTokens.
+func (s *State) FitsTokens(nonterminals []string, terminals int) bool {
+	if s.MaxTokens <= 0 || s.MinDerivations == nil {
+		return true
+	}
+	min, ok := minOfSeq(nonterminals, s.MinDerivations)
+	return ok && s.Tokens+s.Reserved+min.Tokens+terminals <= s.MaxTokens
+}
+
+// MinTokens returns the least number of tokens which the nonterminals derive.
+func (s *State) MinTokens(nonterminals []string) int {
+	min, _ := minOfSeq(nonterminals, s.MinDerivations)
+	return min.Tokens
+}
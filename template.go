@@ -0,0 +1,236 @@
+package sqlgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxInstanceDepth bounds the nesting of instances such as
+// opt(comma_list(expr)), so that a template which instantiates itself with
+// ever larger arguments is an error rather than an endless expansion.
+const maxInstanceDepth = 16
+
+// instanceName returns the name of the instance of the template name with
+// args, such as comma_list(expr), which is also how it is referred to.
+func instanceName(name string, args []string) string {
+	return name + "(" + strings.Join(args, ",") + ")"
+}
+
+// splitInstance splits an instance name into the name of its template and
+// its arguments. It returns false for any other symbol.
+func splitInstance(sym string) (string, []string, bool) {
+	i := strings.IndexByte(sym, '(')
+	if i <= 0 || isLiteral(sym) || matchParen(sym, i, nil) != len(sym)-1 {
+		return "", nil, false
+	}
+	var args []string
+	start := i + 1
+	matchParen(sym, i, func(comma int) {
+		args = append(args, sym[start:comma])
+		start = comma + 1
+	})
+	return sym[:i], append(args, sym[start:len(sym)-1]), true
+}
+
+// matchParen returns the index of the parenthesis which closes the one at
+// open in str, skipping quoted strings, or -1 if there is none. It calls
+// comma, if not nil, with the index of every comma at the top level.
+func matchParen(str string, open int, comma func(int)) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case c == ',' && depth == 1 && comma != nil:
+			comma(i)
+		}
+	}
+	return -1
+}
+
+// template is a parameterized production, such as
+//
+//	comma_list(X): X | comma_list(X) ',' X
+type template struct {
+	prod   *Production
+	params []string
+}
+
+// expandTemplates replaces the templates of prods by ordinary productions,
+// one for every instance which the other productions refer to. An instance
+// takes the place of its template. The parameters of a template are names in
+// upper case, such as X in comma_list(X), whether or not a production has the
+// same name. A parameterized head without parameters, such as
+// comma_list(expr), is an ordinary production which defines the instance
+// itself.
+func expandTemplates(prods []*Production) ([]*Production, error) {
+	defined := map[string]bool{}
+	for _, p := range prods {
+		if _, _, ok := splitInstance(p.head); !ok {
+			defined[p.head] = true
+		}
+	}
+	templates := map[string]*template{}
+	for _, p := range prods {
+		name, params, ok := splitInstance(p.head)
+		if ok {
+			var err error
+			if ok, err = areParams(params); err != nil {
+				return nil, fmt.Errorf("%s: %v", p.head, err)
+			}
+		}
+		if !ok {
+			defined[p.head] = true
+			continue
+		}
+		if _, ok := templates[name]; ok {
+			return nil, fmt.Errorf("template '%s' is defined twice", name)
+		}
+		if defined[name] {
+			return nil, fmt.Errorf("template '%s' is also a production", name)
+		}
+		templates[name] = &template{prod: p, params: params}
+	}
+	instances := map[*Production][]*Production{}
+	var pending []*Production
+	for _, p := range prods {
+		if _, _, ok := splitInstance(p.head); !ok || defined[p.head] {
+			pending = append(pending, p)
+		}
+	}
+	for len(pending) != 0 {
+		p := pending[0]
+		pending = pending[1:]
+		for _, body := range p.bodyList {
+			for _, sym := range body.seq {
+				if defined[sym] {
+					continue
+				}
+				name, args, ok := splitInstance(sym)
+				if !ok {
+					continue
+				}
+				t, ok := templates[name]
+				if !ok {
+					return nil, fmt.Errorf("template '%s' not found", name)
+				}
+				if len(args) != len(t.params) {
+					return nil, fmt.Errorf("template '%s' takes %d arguments, get %d in %s",
+						name, len(t.params), len(args), sym)
+				}
+				if instanceDepth(sym) > maxInstanceDepth {
+					return nil, fmt.Errorf("instances of template '%s' nest too deep", name)
+				}
+				instance := t.instantiate(sym, args)
+				defined[sym] = true
+				instances[t.prod] = append(instances[t.prod], instance)
+				pending = append(pending, instance)
+			}
+		}
+	}
+
+	var ret []*Production
+	for _, p := range prods {
+		if name, _, ok := splitInstance(p.head); ok && templates[name] != nil && templates[name].prod == p {
+			ret = append(ret, instances[p]...)
+		} else {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
+// areParams reports whether args are the parameters of a template rather than
+// the arguments of an instance. It is an error to mix both, or to repeat a
+// parameter.
+func areParams(args []string) (bool, error) {
+	seen := map[string]bool{}
+	for _, a := range args {
+		if !isParam(a) {
+			continue
+		}
+		if seen[a] {
+			return false, fmt.Errorf("parameter %s is repeated", a)
+		}
+		seen[a] = true
+	}
+	if len(seen) != 0 && len(seen) != len(args) {
+		return false, fmt.Errorf("parameters and arguments are mixed")
+	}
+	return len(seen) != 0, nil
+}
+
+// isParam reports whether arg is a name in upper case, such as X or ITEM_2.
+func isParam(arg string) bool {
+	for i, c := range arg {
+		if !(c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return arg != ""
+}
+
+// instanceDepth returns how deep instances nest in sym, 0 if sym isn't an
+// instance.
+func instanceDepth(sym string) int {
+	_, args, ok := splitInstance(sym)
+	if !ok {
+		return 0
+	}
+	max := 0
+	for _, a := range args {
+		if d := instanceDepth(a); d > max {
+			max = d
+		}
+	}
+	return max + 1
+}
+
+// instantiate returns the production name, with the parameters of t bound
+// to args.
+func (t *template) instantiate(name string, args []string) *Production {
+	binding := make(map[string]string, len(args))
+	for i, param := range t.params {
+		binding[param] = args[i]
+	}
+	ret := &Production{head: name, maxLoop: t.prod.maxLoop}
+	for _, body := range t.prod.bodyList {
+		var seq []string
+		for _, sym := range body.seq {
+			seq = append(seq, substitute(sym, binding))
+		}
+		body.seq = seq
+		ret.bodyList = append(ret.bodyList, body)
+	}
+	return ret
+}
+
+// substitute replaces the parameters in sym, which may be an instance.
+func substitute(sym string, binding map[string]string) string {
+	if arg, ok := binding[sym]; ok {
+		return arg
+	}
+	name, args, ok := splitInstance(sym)
+	if !ok {
+		return sym
+	}
+	for i, a := range args {
+		args[i] = substitute(a, binding)
+	}
+	return instanceName(name, args)
+}
@@ -0,0 +1,121 @@
+package sqlgen
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const templateGrammar = `%start stmt
+stmt: 'SELECT' comma_list(expr) opt(where_clause) 'FROM' sep_list(tbl, ',')
+expr: 'a' | 'b'
+where_clause: 'WHERE' opt(comma_list( expr ))
+tbl: 't'
+
+comma_list(X): X | comma_list(X) ',' X
+opt(X) [3]: %empty | X #some
+sep_list(X, S): X | X S sep_list(X, S)
+`
+
+func TestParseTemplates(t *testing.T) {
+	g, err := ParseGrammarString(templateGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `%start stmt
+
+stmt: 'SELECT' comma_list(expr) opt(where_clause) 'FROM' sep_list(tbl,',')
+
+expr: 'a'
+| 'b'
+
+where_clause: 'WHERE' opt(comma_list(expr))
+
+tbl: 't'
+
+comma_list(expr): expr
+| comma_list(expr) ',' expr
+
+opt(where_clause) [3]: %empty
+| where_clause #some
+
+opt(comma_list(expr)) [3]: %empty
+| comma_list(expr) #some
+
+sep_list(tbl,','): tbl
+| tbl ',' sep_list(tbl,',')
+`
+	if g.String() != expect {
+		t.Errorf("expect:\n%s\nget:\n%s", expect, g)
+	}
+	// The instances are ordinary productions, which parse back as such.
+	again, err := ParseGrammarString(g.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.ProductionMap(), g.ProductionMap()) {
+		t.Errorf("the grammar changed:\n%s", again)
+	}
+
+	gen, err := NewGenerator(g.ProductionMap(), g.Start(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt := regexp.MustCompile(`^SELECT [ab](, [ab])*( WHERE( [ab](, [ab])*)?)? FROM t(, t)*$`)
+	for i := 0; i < 20; i++ {
+		rec, err := gen.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stmt.MatchString(rec.SQL) {
+			t.Errorf("unexpected statement %q", rec.SQL)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for src, msg := range map[string]string{
+		"s: f(a)\na: 'a'":                             "template 'f' not found",
+		"s: f(a)\na: 'a'\nf(X, Y): X Y":               "template 'f' takes 2 arguments, get 1",
+		"s: f(a)\na: 'a'\nf(X): X | f(g(X))\ng(X): X": "nest too deep",
+		"s: f(a)\na: 'a'\nf(X): X\nf(Y): Y":           "template 'f' is defined twice",
+		"s: f(a)\na: 'a'\nf(X): X\nf: 'f'":            "template 'f' is also a production",
+		"s: 'a'(b)":                                   "unexpected arguments",
+		"s: f()":                                      "line 1",
+		"s: f(a)\na: 'a'\nf(X, X): X":                 "parameter X is repeated",
+		"s: f(a)\na: 'a'\nf(X, a): X":                 "parameters and arguments are mixed",
+	} {
+		_, err := ParseGrammarString(src)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expect error %q, get %v", src, msg, err)
+		}
+	}
+
+	// A head whose arguments are productions defines an instance itself,
+	// which takes precedence over the template.
+	g, err := ParseGrammarString("s: f(a) f(b)\na: 'a'\nb: 'b'\nf(X): X X\nf(a): 'A'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Productions()) != 5 || g.Lookup("f(a)").String() != "f(a): 'A'\n" ||
+		g.Lookup("f(b)").String() != "f(b): b b\n" {
+		t.Errorf("unexpected grammar:\n%s", g)
+	}
+
+	// A production named like a parameter, as an overlay may add, doesn't
+	// turn the template into an instance.
+	g, err = ParseGrammarString("s: opt(a) opt(X)\na: 'a'\nX: 'x'\nopt(X): %empty | X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Lookup("opt(a)").String() != "opt(a): %empty\n| a\n" || g.Lookup("opt(X)") == nil || g.Lookup("X") == nil {
+		t.Errorf("unexpected grammar:\n%s", g)
+	}
+
+	// Parentheses in literal arguments don't count as nesting.
+	parens := "'" + strings.Repeat("(", maxInstanceDepth+1) + "'"
+	if _, err := ParseGrammarString("s: f(" + parens + ")\nf(X): X"); err != nil {
+		t.Errorf("expect literal parentheses to be allowed, get %v", err)
+	}
+}
@@ -1,23 +1,33 @@
 package sqlgen
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"strings"
-	"unicode"
 )
 
+// BuildProdMap maps the productions by head. It panics if a production is
+// missing or defined twice.
 func BuildProdMap(prods []*Production) map[string]*Production {
+	ret, err := buildProdMap(prods)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ret
+}
+
+// buildProdMap is like BuildProdMap but returns an error instead of panic.
+func buildProdMap(prods []*Production) (map[string]*Production, error) {
 	ret := make(map[string]*Production)
 	for _, v := range prods {
+		if _, ok := ret[v.head]; ok {
+			return nil, fmt.Errorf("Production '%s' is defined twice", v.head)
+		}
 		ret[v.head] = v
 	}
-	checkProductionMap(ret)
-	return ret
+	return ret, checkProductionMap(ret)
 }
 
-func checkProductionMap(productionMap map[string]*Production) {
+func checkProductionMap(productionMap map[string]*Production) error {
 	for _, production := range productionMap {
 		for _, seqs := range production.bodyList {
 			for _, seq := range seqs.seq {
@@ -25,11 +35,12 @@ func checkProductionMap(productionMap map[string]*Production) {
 					continue
 				}
 				if _, exist := productionMap[seq]; !exist {
-					panic(fmt.Sprintf("Production '%s' not found", seq))
+					return fmt.Errorf("Production '%s' not found", seq)
 				}
 			}
 		}
 	}
+	return nil
 }
 
 func breadthFirstSearch(prodName string, prodMap map[string]*Production, visitors ...func(*Production)) (map[string]struct{}, error) {
@@ -63,73 +74,106 @@ func breadthFirstSearch(prodName string, prodMap map[string]*Production, visitor
 	return resultSet, nil
 }
 
+// ParseYacc parses a grammar file. Productions are separated by their heads,
+// so blank lines and line breaks don't matter. An %include is relative to
+// the including file.
 func ParseYacc(yaccFilePath string) ([]*Production, error) {
-	file, err := os.Open(yaccFilePath)
-	if err != nil {
+	l := newFileLoader()
+	if err := l.load("", yaccFilePath); err != nil {
 		return nil, err
 	}
-
-	prodStrs := splitProdStr(bufio.NewReader(file))
-	return parseProdStr(prodStrs)
+	return l.productions()
 }
 
-func parseProdStr(prodStrs []string) ([]*Production, error) {
-	bnfParser := NewParser()
-	var ret []*Production
-	for _, p := range prodStrs {
-		r, _, err := bnfParser.Parse(p)
-		if err != nil {
-			return nil, err
-		}
-		ret = append(ret, r)
+// ParseYaccString is like ParseYacc but parses the grammar itself. An
+// %include is an error, as there is no file to resolve it against.
+func ParseYaccString(grammar string) ([]*Production, error) {
+	l := newFileLoader()
+	if err := l.loadString("", grammar); err != nil {
+		return nil, err
 	}
-	return ret, nil
+	return l.productions()
 }
 
-func splitProdStr(prodReader *bufio.Reader) []string {
-	var ret []string
-	var sb strings.Builder
-	time2Exit := false
-	for !time2Exit {
-		for {
-			str, err := prodReader.ReadString('\n')
-			if err != nil {
-				time2Exit = true
-				if !isWhitespace(str) {
-					sb.WriteString(str)
-				}
-				break
-			}
-			if isWhitespace(str) && sb.Len() != 0 {
-				ret = append(ret, sb.String())
-				sb.Reset()
-			} else {
-				sb.WriteString(str)
-			}
-		}
-	}
-	if sb.Len() != 0 {
-		ret = append(ret, sb.String())
+// literal returns the text of a terminal, which is enclosed in either single
+// or double quotes.
+func literal(token string) (string, bool) {
+	if isLiteral(token) {
+		return unescapeLiteral(token[1 : len(token)-1]), true
 	}
-	return ret
+	return "", false
 }
 
-func isWhitespace(str string) bool {
-	for _, c := range str {
-		if !unicode.IsSpace(c) {
-			return false
-		}
+func isLiteral(token string) bool {
+	if len(token) < 2 {
+		return false
 	}
-	return true
+	q := token[0]
+	return (q == '\'' || q == '"') && token[len(token)-1] == q
 }
 
-func literal(token string) (string, bool) {
-	if isLiteral(token) {
-		return strings.Trim(token, "'"), true
+// isEscape reports whether a backslash followed by r is an escape sequence.
+func isEscape(r rune) bool {
+	return strings.ContainsRune(`nrt0'"\`, r)
+}
+
+// quoteLiteral quotes text as a terminal, the reverse of literal.
+func quoteLiteral(text string) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, r := range text {
+		switch r {
+		case '\'', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case 0:
+			sb.WriteString(`\0`)
+		default:
+			sb.WriteRune(r)
+		}
 	}
-	return "", false
+	sb.WriteByte('\'')
+	return sb.String()
 }
 
-func isLiteral(token string) bool {
-	return strings.HasPrefix(token, "'") && strings.HasSuffix(token, "'")
+// unescapeLiteral interprets the escape sequences in the text of a terminal:
+// \n, \r and \t stand for newline, carriage return and tab, \0 for the NUL
+// character, and \', \" and \\ for the quote or backslash. The scanner
+// rejects any other escape sequence, see isEscape.
+func unescapeLiteral(str string) string {
+	if !strings.ContainsRune(str, '\\') {
+		return str
+	}
+	var sb strings.Builder
+	escaped := false
+	for _, r := range str {
+		if !escaped {
+			if r == '\\' {
+				escaped = true
+			} else {
+				sb.WriteRune(r)
+			}
+			continue
+		}
+		escaped = false
+		switch r {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '0':
+			sb.WriteByte(0)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
 }
@@ -1,8 +1,7 @@
 package sqlgen
 
 import (
-	"bufio"
-	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -29,29 +28,35 @@ func TestParseYacc(t *testing.T) {
 
 }
 
-func TestProdSplitter(t *testing.T) {
-	buf := bufio.NewReader(bytes.NewBufferString(`deallocate_or_drop: DEALLOCATE_SYM
-| DROP
+func TestParseYaccStringLayout(t *testing.T) {
+	grammar := `stmt: select_stmt
+| delete_stmt
+select_stmt: 'SELECT' field
 
-prepare: PREPARE_SYM ident FROM prepare_src`))
-	res := splitProdStr(buf)
-
-	expected := []string{"deallocate_or_drop: DEALLOCATE_SYM\n| DROP\n", "prepare: PREPARE_SYM ident FROM prepare_src"}
-	for i, v := range res {
-		if v != expected[i] {
-			t.Errorf("expect: '%s', get: '%s'", expected[i], v)
+| 'SELECT' field ';' ;
+delete_stmt [2] : 'DELETE' 'FROM' 't'; field: 'a' | 'b:'`
+	prods, err := ParseYaccString(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"stmt": 2, "select_stmt": 2, "delete_stmt": 1, "field": 2}
+	if len(prods) != len(expected) {
+		t.Fatalf("expect %d productions, get %d", len(expected), len(prods))
+	}
+	for _, p := range prods {
+		if n, ok := expected[p.head]; !ok || len(p.bodyList) != n {
+			t.Errorf("unexpected production: %s", p)
 		}
 	}
-}
-
-func TestIsWhitespace(t *testing.T) {
-	if !isWhitespace("\t \n") {
-		t.Error()
+	if seq := prods[1].bodyList[1].seq; len(seq) != 3 || seq[2] != "';'" {
+		t.Errorf("unexpected alternative: %v", seq)
 	}
-	if isWhitespace("  t  ") {
-		t.Error()
+	if prods[2].maxLoop != 2 {
+		t.Errorf("expect [2] on the head, get %d", prods[2].maxLoop)
 	}
-	if !isWhitespace("\n  \t  ") {
-		t.Error()
+
+	_, err = ParseYaccString("a: 'x'\nb: 'y' |\n| : c")
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expect an error on line 3, get %v", err)
 	}
 }
@@ -0,0 +1,137 @@
+package sqlgen
+
+import (
+	"math/rand"
+	"strings"
+	"unicode"
+)
+
+// LexicalVariation changes the layout of a statement without changing its
+// meaning for MySQL, to stress the lexer. Every probability applies to each
+// token, or to each space between two tokens.
+type LexicalVariation struct {
+	// KeywordCase is the probability of changing the case of a keyword.
+	KeywordCase float64
+	// Whitespace is the probability of replacing a space by other whitespace.
+	Whitespace float64
+	// Comment is the probability of inserting a /* */, -- or # comment in
+	// place of a space.
+	Comment float64
+	// VersionComment is the probability of wrapping a token in a /*! */
+	// comment, which MySQL executes.
+	VersionComment float64
+	// QuoteIdent is the probability of backquoting an identifier.
+	QuoteIdent float64
+
+	// IsKeyword and IsIdentifier classify tokens. By default, a word is a
+	// keyword if IsSQLKeyword says so, and any other word, such as a table
+	// or column name, is an identifier, whose case is kept.
+	IsKeyword    func(token string) bool
+	IsIdentifier func(token string) bool
+}
+
+// DefaultLexicalVariation returns a variation which changes some of the
+// tokens of most statements.
+func DefaultLexicalVariation() *LexicalVariation {
+	return &LexicalVariation{
+		KeywordCase:    0.3,
+		Whitespace:     0.2,
+		Comment:        0.05,
+		VersionComment: 0.02,
+		QuoteIdent:     0.3,
+	}
+}
+
+var (
+	variedWhitespaces = []string{"  ", "\t", "\n", " \n\t", "\r\n"}
+	variedComments    = []string{" /* sqlgen */ ", "/**/", " -- sqlgen\n", " #sqlgen\n", "\n/* multi\nline */\n"}
+)
+
+// Join joins tokens like spacing does, varying the result with r.
+func (v *LexicalVariation) Join(r *rand.Rand, tokens []string, spacing *Spacing) string {
+	var words []string
+	for _, t := range tokens {
+		if t != "" {
+			words = append(words, t)
+		}
+	}
+	// call reports whether the word i is a function name, which the next
+	// token sticks to as a word. Both stay bare.
+	call := func(i int) bool {
+		return i >= 0 && i+1 < len(words) && spacing != nil && spacing.NoSpaceAfterWord[words[i+1]] &&
+			spacing.glued(words[i], words[i+1])
+	}
+	var sb strings.Builder
+	for i, t := range words {
+		if i > 0 && !spacing.glued(words[i-1], t) {
+			sb.WriteString(v.separator(r))
+		}
+		sb.WriteString(v.token(r, t, call(i) || call(i-1)))
+	}
+	return sb.String()
+}
+
+func (v *LexicalVariation) separator(r *rand.Rand) string {
+	switch {
+	case chance(r, v.Comment):
+		return variedComments[r.Intn(len(variedComments))]
+	case chance(r, v.Whitespace):
+		return variedWhitespaces[r.Intn(len(variedWhitespaces))]
+	default:
+		return " "
+	}
+}
+
+func (v *LexicalVariation) token(r *rand.Rand, t string, bare bool) string {
+	isKeyword, isIdentifier := v.IsKeyword, v.IsIdentifier
+	if isKeyword == nil {
+		isKeyword = IsSQLKeyword
+	}
+	if isIdentifier == nil {
+		isIdentifier = func(t string) bool { return isWord(t) && !isKeyword(t) }
+	}
+
+	if isKeyword(t) && chance(r, v.KeywordCase) {
+		t = varyCase(r, t)
+	} else if isIdentifier(t) && !bare && chance(r, v.QuoteIdent) {
+		t = "`" + strings.Replace(t, "`", "``", -1) + "`"
+	}
+	if !bare && chance(r, v.VersionComment) {
+		t = "/*!40100 " + t + " */"
+	}
+	return t
+}
+
+func varyCase(r *rand.Rand, t string) string {
+	switch r.Intn(3) {
+	case 0:
+		return strings.ToLower(t)
+	case 1:
+		rs := []rune(strings.ToLower(t))
+		rs[0] = unicode.ToUpper(rs[0])
+		return string(rs)
+	default:
+		rs := []rune(t)
+		for i := range rs {
+			if r.Intn(2) == 0 {
+				rs[i] = unicode.ToLower(rs[i])
+			} else {
+				rs[i] = unicode.ToUpper(rs[i])
+			}
+		}
+		return string(rs)
+	}
+}
+
+func chance(r *rand.Rand, p float64) bool {
+	return p > 0 && r.Float64() < p
+}
+
+func isWord(t string) bool {
+	for i, c := range t {
+		if !(unicode.IsLetter(c) || c == '_' || i > 0 && unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return t != ""
+}
@@ -0,0 +1,76 @@
+package sqlgen
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// unvary undoes a lexical variation except for the case of keywords.
+func unvary(sql string) string {
+	var sb strings.Builder
+	for len(sql) > 0 {
+		switch {
+		case strings.HasPrefix(sql, "/*!40100 "):
+			end := strings.Index(sql, " */")
+			sb.WriteString(" " + sql[len("/*!40100 "):end] + " ")
+			sql = sql[end+len(" */"):]
+		case strings.HasPrefix(sql, "/*"):
+			sb.WriteByte(' ')
+			sql = sql[strings.Index(sql[2:], "*/")+4:]
+		case strings.HasPrefix(sql, "-- "), strings.HasPrefix(sql, "#"):
+			sb.WriteByte(' ')
+			sql = sql[strings.IndexByte(sql, '\n'):]
+		default:
+			sb.WriteByte(sql[0])
+			sql = sql[1:]
+		}
+	}
+	return strings.Join(strings.Fields(strings.Replace(sb.String(), "`", "", -1)), " ")
+}
+
+func TestLexicalVariation(t *testing.T) {
+	tokens := strings.Fields("select a , COUNT ( b ) FROM t WHERE c = 1")
+	expected := strings.Join(tokens, " ")
+	v := &LexicalVariation{KeywordCase: 0.5, Whitespace: 0.5, Comment: 0.5, VersionComment: 0.2, QuoteIdent: 0.5}
+	varied := 0
+	for seed := int64(0); seed < 100; seed++ {
+		sql := v.Join(rand.New(rand.NewSource(seed)), tokens, nil)
+		if again := v.Join(rand.New(rand.NewSource(seed)), tokens, nil); again != sql {
+			t.Fatalf("seed %d is not reproducible: '%s' and '%s'", seed, sql, again)
+		}
+		if sql != expected {
+			varied++
+		}
+		if res := unvary(sql); !strings.EqualFold(res, expected) {
+			t.Errorf("variation changes the statement: %q -> %q", sql, res)
+		}
+	}
+	if varied < 90 {
+		t.Errorf("only %d of 100 statements are varied", varied)
+	}
+
+	all := &LexicalVariation{KeywordCase: 1, QuoteIdent: 1}
+	if sql := all.Join(rand.New(rand.NewSource(1)), tokens, SQLSpacing()); strings.Count(sql, "`") != 8 || strings.Contains(sql, "FROM") {
+		t.Errorf("identifiers should be quoted and keywords recased: '%s'", sql)
+	}
+	// Function names stay bare, as MySQL takes a call only without anything
+	// between the name and the parenthesis.
+	wrap := &LexicalVariation{QuoteIdent: 1, VersionComment: 1}
+	if sql := wrap.Join(rand.New(rand.NewSource(1)), tokens, SQLSpacing()); !strings.Contains(sql, " COUNT(") {
+		t.Errorf("the function name should stay bare: '%s'", sql)
+	}
+	for _, w := range []string{"select", "Where", "VALUES", "interval"} {
+		if !IsSQLKeyword(w) {
+			t.Errorf("expect %s to be a keyword", w)
+		}
+	}
+	for _, w := range []string{"t", "a", "COUNT", "'select'", ""} {
+		if IsSQLKeyword(w) {
+			t.Errorf("expect %q not to be a keyword", w)
+		}
+	}
+	if sql := (&LexicalVariation{}).Join(rand.New(rand.NewSource(1)), tokens, SQLSpacing()); sql != SQLSpacing().Join(tokens) {
+		t.Errorf("zero variation should keep the statement: '%s'", sql)
+	}
+}